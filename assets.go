@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+	"github.com/securityfirst/tent/repo"
+)
+
+// AssetReport lists the unused, duplicated and misnamed images of the legacy
+// repository in TENT_REPODIR, if ASSETS_MODE is "legacy", or of the tent tree.
+func AssetReport() {
+	issues := make(report)
+	switch m := os.Getenv("ASSETS_MODE"); m {
+	case "legacy":
+		r, err := repo.Local(repoDir, branch)
+		if err != nil {
+			log.Fatalf("Repo error: %s", err)
+		}
+		r.Pull()
+		checkLegacyAssets(r, issues)
+	case "", "tent":
+		src, err := getSource()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		root, err := decodeRoot(src)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		checkTreeAssets(root, issues)
+	default:
+		log.Fatalln("unknown mode:", m)
+	}
+	issues.print("Assets")
+}
+
+// assetIndex finds the assets referenced with a different case or extension.
+type assetIndex struct {
+	names map[string]bool
+	fold  map[string][]string
+}
+
+func newAssetIndex(names []string) assetIndex {
+	x := assetIndex{names: make(map[string]bool), fold: make(map[string][]string)}
+	for _, n := range names {
+		x.names[n] = true
+		x.fold[assetKey(n)] = append(x.fold[assetKey(n)], n)
+	}
+	return x
+}
+
+// assetKey returns the lower case name without extension.
+func assetKey(name string) string {
+	name = path.Base(name)
+	return strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+}
+
+// resolve returns the assets with the name of the reference, that is the
+// reference itself if it exists, or the ones with a different case or
+// extension.
+func (x assetIndex) resolve(ref string) (names []string, exact bool) {
+	if x.names[ref] {
+		return []string{ref}, true
+	}
+	return x.fold[assetKey(ref)], false
+}
+
+// check reports a missing or misnamed reference, marking the used assets.
+func (x assetIndex) check(ref string, used map[string]bool, issue func(format string, args ...interface{})) {
+	names, exact := x.resolve(ref)
+	for _, n := range names {
+		used[n] = true
+	}
+	switch {
+	case exact:
+	case len(names) == 0:
+		issue("image %q not found", ref)
+	default:
+		issue("image %q differs in case or extension from %s", ref, strings.Join(names, ", "))
+	}
+}
+
+// duplicates returns the groups of names with the same contents.
+func duplicates(data map[string][]byte) [][]string {
+	var byHash = make(map[[sha256.Size]byte][]string)
+	for name, b := range data {
+		h := sha256.Sum256(b)
+		byHash[h] = append(byHash[h], name)
+	}
+	var groups [][]string
+	for _, names := range byHash {
+		if len(names) > 1 {
+			sort.Strings(names)
+			groups = append(groups, names)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0] < groups[j][0] })
+	return groups
+}
+
+// checkLegacyAssets compares the assets of the repository with the images
+// used by the items of all languages.
+func checkLegacyAssets(r *repo.Repo, issues report) {
+	var (
+		names []string
+		data  = make(map[string][]byte)
+		used  = make(map[string]bool)
+	)
+	// Assets are shared by all locales, and the tree of a locale without
+	// categories contains only them.
+	tree, _ := r.Tree("", false).(map[string]interface{})
+	names, _ = tree["assets"].([]string)
+	for _, n := range names {
+		if a := r.Asset(n); a != nil {
+			data[n] = []byte(a.Content)
+		}
+	}
+	x := newAssetIndex(names)
+	for _, loc := range r.Locale() {
+		for _, cname := range r.Categories(loc) {
+			c := r.Category(cname, loc)
+			for _, sname := range c.Subcategories() {
+				s := c.Sub(sname)
+				for _, dname := range s.DifficultyNames() {
+					d := s.Difficulty(dname)
+					for _, iname := range d.ItemNames() {
+						i := d.Item(iname)
+						for _, m := range imgFinder.FindAllStringSubmatch(i.Body, -1) {
+							x.check(path.Base(m[1]), used, func(format string, args ...interface{}) {
+								issues.add(loc, i.ID, format, args...)
+							})
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, n := range names {
+		if !used[n] {
+			issues.add("assets", n, "not used")
+		}
+	}
+	for _, g := range duplicates(data) {
+		issues.add("assets", g[0], "same image as %s", strings.Join(g[1:], ", "))
+	}
+}
+
+// checkTreeAssets compares the pictures of each category with the images of
+// its segments and its icon. Translations can use the pictures of the project
+// language. Duplicates are searched in each language, since the same pictures
+// are expected in all of them.
+func checkTreeAssets(root *core.Root, issues report) {
+	source := findCategory(root.Category, []string{projectLang})
+	for i := range root.Sub {
+		lang := &root.Sub[i]
+		data := make(map[string][]byte)
+		walkTree(lang, func(parts []string, cat *core.Category) {
+			var (
+				names []string
+				used  = make(map[string]bool)
+				dir   = path.Join(parts...)
+			)
+			for _, cmp := range cat.Components {
+				if p, ok := cmp.(*core.Picture); ok {
+					names = append(names, p.ID)
+					data[path.Join(dir, p.ID)] = p.Data
+				}
+			}
+			if lang != source && source != nil {
+				if src := findCategory(source, parts); src != nil {
+					for _, cmp := range src.Components {
+						if p, ok := cmp.(*core.Picture); ok {
+							names = append(names, p.ID)
+						}
+					}
+				}
+			}
+			x := newAssetIndex(names)
+			if icon := cat.Meta["icon"]; icon != "" {
+				x.check(icon, used, func(format string, args ...interface{}) {
+					issues.add(lang.ID, dir+"/", "icon: "+format, args...)
+				})
+			}
+			for _, cmp := range cat.Components {
+				s, ok := cmp.(*core.Segment)
+				if !ok {
+					continue
+				}
+				for _, m := range imgFinder.FindAllSubmatch(s.Body, -1) {
+					x.check(path.Base(string(m[1])), used, func(format string, args ...interface{}) {
+						issues.add(lang.ID, path.Join(dir, componentName(s)), format, args...)
+					})
+				}
+			}
+			for _, cmp := range cat.Components {
+				if p, ok := cmp.(*core.Picture); ok && !used[p.ID] {
+					issues.add(lang.ID, path.Join(dir, p.ID), "not used")
+				}
+			}
+		})
+		for _, g := range duplicates(data) {
+			issues.add(lang.ID, g[0], "same image as %s", strings.Join(g[1:], ", "))
+		}
+	}
+}
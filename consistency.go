@@ -0,0 +1,120 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+)
+
+// Consistency compares the structure of each language in CONSISTENCY_LANGS,
+// or of all of them, with the project language. The tree is the directory
+// or the revision in CONSISTENCY_TREE, or the current one. It exits with an
+// error status if any language is not consistent.
+func Consistency() {
+	var (
+		root *core.Root
+		err  error
+	)
+	if name := os.Getenv("CONSISTENCY_TREE"); name != "" {
+		root, err = diffRoot(name)
+	} else {
+		src, e := getSource()
+		if e != nil {
+			log.Fatalln(e)
+		}
+		root, err = decodeRoot(src)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if findCategory(root.Category, []string{projectLang}) == nil {
+		log.Fatalln("project language not found:", projectLang)
+	}
+	var langs []string
+	for _, l := range strings.Split(os.Getenv("CONSISTENCY_LANGS"), ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			langs = append(langs, l)
+		}
+	}
+	if len(langs) == 0 {
+		for _, l := range root.Sub {
+			langs = append(langs, l.ID)
+		}
+	}
+	issues := checkConsistency(indexTree(root), langs)
+	issues.print("Consistency")
+	if len(issues) != 0 {
+		log.Printf("%d language(s) not consistent with %s", len(issues), projectLang)
+		os.Exit(1)
+	}
+}
+
+// checkConsistency reports the categories and components that are missing
+// or extra in the languages, the segments with a different index and the
+// categories with different metadata keys. Missing pictures are ignored,
+// since the ones of the project language are used.
+func checkConsistency(idx map[string]treeEntry, langs []string) report {
+	issues := make(report)
+	for _, lang := range langs {
+		if lang == projectLang {
+			continue
+		}
+		if _, ok := idx[lang+"/"]; !ok {
+			issues.add(lang, "/", "language not found")
+			continue
+		}
+		for p, src := range idx {
+			rel := strings.TrimPrefix(p, projectLang+"/")
+			if rel == p || rel == "" {
+				continue
+			}
+			dst, ok := idx[lang+"/"+rel]
+			switch {
+			case !ok && src.Kind != "picture":
+				issues.add(lang, rel, "missing %s", src.Kind)
+			case !ok:
+			case dst.Kind != src.Kind:
+				issues.add(lang, rel, "%s instead of %s", dst.Kind, src.Kind)
+			case src.Cat != nil:
+				if keys := metaKeys(src.Cat.Meta, dst.Cat.Meta); keys != "" {
+					issues.add(lang, rel, "metadata keys differ: %s", keys)
+				}
+			case src.Kind == "segment":
+				if a, b := src.Cmp.(*core.Segment).Index, dst.Cmp.(*core.Segment).Index; a != b {
+					issues.add(lang, rel, "index %v instead of %v", b, a)
+				}
+			}
+		}
+		for p, dst := range idx {
+			rel := strings.TrimPrefix(p, lang+"/")
+			if rel == p || rel == "" {
+				continue
+			}
+			if _, ok := idx[projectLang+"/"+rel]; !ok {
+				issues.add(lang, rel, "%s not in %s", dst.Kind, projectLang)
+			}
+		}
+	}
+	return issues
+}
+
+// metaKeys returns the keys that are only in one of the maps, with the
+// ones missing in b prefixed by "-" and the extra ones by "+".
+func metaKeys(a, b map[string]string) string {
+	var keys []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			keys = append(keys, "-"+k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, "+"+k)
+		}
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, " ")
+}
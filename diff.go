@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+	"github.com/go-tent/tent/source"
+)
+
+var (
+	diffFrom   = os.Getenv("DIFF_FROM")
+	diffTo     = os.Getenv("DIFF_TO")
+	diffFormat = os.Getenv("DIFF_FORMAT")
+	diffOut    = os.Getenv("DIFF_OUT")
+)
+
+// diffContext is the number of unchanged lines shown around a change.
+const diffContext = 2
+
+// Diff compares the trees of DIFF_FROM and DIFF_TO, that are directories
+// or revisions of PROJECT_URL, and writes the changes to DIFF_OUT, or to
+// the standard output, as text, json or html.
+func Diff() {
+	a, err := diffRoot(diffFrom)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	b, err := diffRoot(diffTo)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	changes := diffTrees(indexTree(a), indexTree(b))
+	var out bytes.Buffer
+	switch diffFormat {
+	case "", "text":
+		writeDiffText(&out, changes)
+	case "json":
+		err = json.NewEncoder(&out).Encode(changes)
+	case "html":
+		err = diffTemplate.Execute(&out, struct {
+			From, To string
+			Changes  []diffChange
+		}{diffFrom, diffTo, changes})
+	default:
+		log.Fatalln("unknown format:", diffFormat)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if diffOut == "" {
+		os.Stdout.Write(out.Bytes())
+	} else if err := writeFile(diffOut, out.Bytes()); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("%d change(s)", len(changes))
+}
+
+// diffRoot decodes the tree of a directory, or of a revision if there's no
+// directory with that name.
+func diffRoot(name string) (*core.Root, error) {
+	var (
+		src source.Source
+		err error
+	)
+	if fi, e := os.Stat(name); e == nil && fi.IsDir() {
+		src = source.NewFile(context.Background(), path.Clean(name))
+	} else if src, err = getRevision(name); err != nil {
+		return nil, err
+	}
+	return decodeRoot(src)
+}
+
+// treeEntry is a category or a component in a tree.
+type treeEntry struct {
+	Kind string
+	Cat  *core.Category
+	Cmp  core.Component
+}
+
+// indexTree returns the entries of the tree by path, categories end with a slash.
+func indexTree(root *core.Root) map[string]treeEntry {
+	idx := make(map[string]treeEntry)
+	var walk func(parts []string, cat *core.Category)
+	walk = func(parts []string, cat *core.Category) {
+		if len(parts) != 0 {
+			idx[path.Join(parts...)+"/"] = treeEntry{Kind: "category", Cat: cat}
+		}
+		for _, cmp := range cat.Components {
+			idx[path.Join(append(parts[:len(parts):len(parts)], componentName(cmp))...)] = treeEntry{Kind: componentKind(cmp), Cmp: cmp}
+		}
+		for i := range cat.Sub {
+			walk(append(parts[:len(parts):len(parts)], cat.Sub[i].ID), &cat.Sub[i])
+		}
+	}
+	walk(nil, root.Category)
+	return idx
+}
+
+func componentKind(cmp core.Component) string {
+	switch cmp.(type) {
+	case *core.Segment:
+		return "segment"
+	case *core.Checks:
+		return "checks"
+	case *core.Form:
+		return "form"
+	case *core.Picture:
+		return "picture"
+	}
+	return "component"
+}
+
+// diffChange is a difference between the trees, Op is added, removed, moved
+// or changed.
+type diffChange struct {
+	Op      string   `json:"op"`
+	Kind    string   `json:"kind"`
+	Path    string   `json:"path"`
+	To      string   `json:"to,omitempty"`
+	Changes []string `json:"changes,omitempty"`
+	Diff    []string `json:"diff,omitempty"`
+}
+
+// diffTrees returns the changes from a to b, sorted by path. A removed entry
+// with an added one of the same language, kind and name is a move, entries
+// that moved with their category are reported only if they changed.
+func diffTrees(a, b map[string]treeEntry) []diffChange {
+	var (
+		changes        []diffChange
+		removed, added = make(map[string][]string), make(map[string][]string)
+		moves, moved   = make(map[string]string), make(map[string]bool)
+	)
+	for p, e := range a {
+		if _, ok := b[p]; !ok {
+			removed[moveKey(p, e)] = append(removed[moveKey(p, e)], p)
+		}
+	}
+	for p, e := range b {
+		if _, ok := a[p]; !ok {
+			added[moveKey(p, e)] = append(added[moveKey(p, e)], p)
+		}
+	}
+	for k, from := range removed {
+		if to := added[k]; len(from) == 1 && len(to) == 1 {
+			moves[from[0]], moved[to[0]] = to[0], true
+		}
+	}
+	for p, ea := range a {
+		if eb, ok := b[p]; ok {
+			if c, d := compareEntries(ea, eb); c != nil || d != nil {
+				changes = append(changes, diffChange{Op: "changed", Kind: ea.Kind, Path: p, Changes: c, Diff: d})
+			}
+			continue
+		}
+		to, ok := moves[p]
+		if !ok {
+			changes = append(changes, diffChange{Op: "removed", Kind: ea.Kind, Path: p})
+			continue
+		}
+		c, d := compareEntries(ea, b[to])
+		switch {
+		case !movedWithParent(p, to, moves):
+			changes = append(changes, diffChange{Op: "moved", Kind: ea.Kind, Path: p, To: to, Changes: c, Diff: d})
+		case c != nil || d != nil:
+			changes = append(changes, diffChange{Op: "changed", Kind: ea.Kind, Path: to, Changes: c, Diff: d})
+		}
+	}
+	for p, e := range b {
+		if _, ok := a[p]; !ok && !moved[p] {
+			changes = append(changes, diffChange{Op: "added", Kind: e.Kind, Path: p})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Path != changes[j].Path {
+			return changes[i].Path < changes[j].Path
+		}
+		return changes[i].Op < changes[j].Op
+	})
+	return changes
+}
+
+// moveKey returns the language, kind and name of an entry.
+func moveKey(p string, e treeEntry) string {
+	return strings.SplitN(p, "/", 2)[0] + " " + e.Kind + " " + path.Base(p)
+}
+
+// movedWithParent returns true if the entry kept its place in a moved category.
+func movedWithParent(from, to string, moves map[string]string) bool {
+	for a, b := range moves {
+		if a != from && strings.HasSuffix(a, "/") && strings.HasPrefix(from, a) && strings.HasPrefix(to, b) && from[len(a):] == to[len(b):] {
+			return true
+		}
+	}
+	return false
+}
+
+// compareEntries returns the changed properties and the line diff of the
+// contents of two entries of the same kind.
+func compareEntries(a, b treeEntry) (changes, diff []string) {
+	switch a.Kind {
+	case "category":
+		if a.Cat.Index != b.Cat.Index {
+			changes = append(changes, fmt.Sprintf("index: %v -> %v", a.Cat.Index, b.Cat.Index))
+		}
+		return append(changes, metaChanges(a.Cat.Meta, b.Cat.Meta)...), nil
+	case "segment":
+		sa, sb := a.Cmp.(*core.Segment), b.Cmp.(*core.Segment)
+		if sa.Index != sb.Index {
+			changes = append(changes, fmt.Sprintf("index: %v -> %v", sa.Index, sb.Index))
+		}
+		changes = append(changes, metaChanges(sa.Meta, sb.Meta)...)
+		if !bytes.Equal(sa.Body, sb.Body) {
+			diff = lineDiff(string(sa.Body), string(sb.Body))
+		}
+		return changes, diff
+	case "picture":
+		if pa, pb := a.Cmp.(*core.Picture), b.Cmp.(*core.Picture); !bytes.Equal(pa.Data, pb.Data) {
+			changes = append(changes, fmt.Sprintf("data: %d -> %d bytes", len(pa.Data), len(pb.Data)))
+		}
+		return changes, nil
+	}
+	ea, err := a.Cmp.Encode()
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	eb, err := b.Cmp.Encode()
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	if !bytes.Equal(ea, eb) {
+		diff = lineDiff(string(ea), string(eb))
+	}
+	return nil, diff
+}
+
+// metaChanges returns the changed keys of two metadata maps.
+func metaChanges(a, b map[string]string) []string {
+	if reflect.DeepEqual(a, b) {
+		return nil
+	}
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var changes []string
+	for _, k := range keys {
+		if a[k] != b[k] {
+			changes = append(changes, fmt.Sprintf("%s: %q -> %q", k, a[k], b[k]))
+		}
+	}
+	return changes
+}
+
+// lineDiff returns the lines removed and added from a to b, with some
+// unchanged lines around them and "@@" between the hunks.
+func lineDiff(a, b string) []string {
+	x, y := strings.Split(strings.TrimSuffix(a, "\n"), "\n"), strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []string
+	for i, j := 0, 0; i < len(x) || j < len(y); {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			lines = append(lines, "  "+x[i])
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+x[i])
+			i++
+		default:
+			lines = append(lines, "+ "+y[j])
+			j++
+		}
+	}
+	var (
+		hunks []string
+		last  = -1
+	)
+	for i, l := range lines {
+		if l[0] == ' ' || i <= last {
+			continue
+		}
+		start := i - diffContext
+		if start <= last+1 {
+			start = last + 1
+		} else if last != -1 || start > 0 {
+			hunks = append(hunks, "@@")
+		}
+		if start < 0 {
+			start = 0
+		}
+		end := i + diffContext
+		for k := i + 1; k < len(lines) && k <= end; k++ {
+			if lines[k][0] != ' ' {
+				end = k + diffContext
+			}
+		}
+		if end >= len(lines) {
+			end = len(lines) - 1
+		}
+		if end < i {
+			end = i
+		}
+		hunks = append(hunks, lines[start:end+1]...)
+		last = end
+	}
+	return hunks
+}
+
+var diffSymbols = map[string]string{"added": "+", "removed": "-", "moved": ">", "changed": "~"}
+
+// writeDiffText writes the changes as text, one per line followed by the
+// indented details.
+func writeDiffText(w io.Writer, changes []diffChange) {
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s %s %s", diffSymbols[c.Op], c.Kind, c.Path)
+		if c.To != "" {
+			fmt.Fprintf(w, " -> %s", c.To)
+		}
+		fmt.Fprintln(w)
+		for _, l := range c.Changes {
+			fmt.Fprintln(w, "    "+l)
+		}
+		for _, l := range c.Diff {
+			fmt.Fprintln(w, "    "+l)
+		}
+	}
+}
+
+var diffTemplate = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"symbol": func(op string) string { return diffSymbols[op] },
+	"line": func(l string) string {
+		switch {
+		case strings.HasPrefix(l, "+"):
+			return "add"
+		case strings.HasPrefix(l, "-"):
+			return "del"
+		case l == "@@":
+			return "hunk"
+		}
+		return ""
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.From}} - {{.To}}</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+.change { border-top: 1px solid #ccc; padding: .5em 0; }
+.added h3 { color: #080; }
+.removed h3 { color: #a00; }
+.moved h3 { color: #05a; }
+h3 { font-size: 1em; margin: 0; }
+pre { background: #f6f6f6; padding: .5em; overflow-x: auto; }
+pre span { display: block; }
+.add { background: #dfd; }
+.del { background: #fdd; }
+.hunk { color: #888; }
+</style>
+</head>
+<body>
+<h1>{{.From}} &rarr; {{.To}}</h1>
+<p>{{len .Changes}} change(s)</p>
+{{range .Changes}}<div class="change {{.Op}}">
+<h3>{{symbol .Op}} {{.Kind}} <code>{{.Path}}</code>{{with .To}} &rarr; <code>{{.}}</code>{{end}}</h3>
+{{with .Changes}}<ul>{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{with .Diff}}<pre>{{range .}}<span class="{{line .}}">{{.}}</span>{{end}}</pre>{{end}}
+</div>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+)
+
+// docNode is a category of a language tree, as shown by the exporters.
+type docNode struct {
+	Cat  *core.Category
+	Path []string
+	Sub  []docNode
+}
+
+// newDoc returns the nodes of a language tree, without the skipped categories.
+func newDoc(lang *core.Category, skip ...string) []docNode {
+	var nodes []docNode
+next:
+	for i := range lang.Sub {
+		for _, id := range skip {
+			if lang.Sub[i].ID == id {
+				continue next
+			}
+		}
+		nodes = append(nodes, newDocNode(&lang.Sub[i], nil))
+	}
+	return nodes
+}
+
+func newDocNode(cat *core.Category, parent []string) docNode {
+	n := docNode{Cat: cat, Path: append(parent[:len(parent):len(parent)], cat.ID)}
+	for i := range cat.Sub {
+		n.Sub = append(n.Sub, newDocNode(&cat.Sub[i], n.Path))
+	}
+	return n
+}
+
+// Depth returns the level of the node, starting from 1.
+func (n docNode) Depth() int { return len(n.Path) }
+
+// Title returns the node title.
+func (n docNode) Title() string { return n.Cat.Meta["title"] }
+
+// Description returns the node description.
+func (n docNode) Description() string { return n.Cat.Meta["description"] }
+
+// Anchor returns the node anchor ID.
+func (n docNode) Anchor() string { return anchorID(n.Path) }
+
+// Items returns the exported components of the node.
+func (n docNode) Items() []docItem {
+	var items []docItem
+	for _, cmp := range n.Cat.Components {
+		switch v := cmp.(type) {
+		case *core.Segment:
+			items = append(items, docItem{Path: n.Path, Segment: v})
+		case *core.Checks:
+			items = append(items, docItem{Path: n.Path, Checks: v})
+		case *core.Form:
+			items = append(items, docItem{Path: n.Path, Form: v})
+		}
+	}
+	return items
+}
+
+// IsGlossary returns true for the glossary and its subcategories.
+func (n docNode) IsGlossary() bool {
+	return n.Path[0] == "glossary" || n.Cat.Meta["template"] == "glossary"
+}
+
+// Entries returns the items shown in tables of contents, all except checklists.
+func (n docNode) Entries() []docItem {
+	var items []docItem
+	for _, i := range n.Items() {
+		if i.Checks == nil {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// Picture returns the node picture with the name base, or nil if not found.
+func (n docNode) Picture(name string) *core.Picture {
+	for _, cmp := range n.Cat.Components {
+		if p, ok := cmp.(*core.Picture); ok && path.Base(p.ID) == path.Base(name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// docItem is a component of a docNode, only one of the components is set.
+type docItem struct {
+	Path    []string
+	Segment *core.Segment
+	Checks  *core.Checks
+	Form    *core.Form
+}
+
+// Component returns the component of the item.
+func (i docItem) Component() core.Component {
+	switch {
+	case i.Segment != nil:
+		return i.Segment
+	case i.Checks != nil:
+		return i.Checks
+	default:
+		return i.Form
+	}
+}
+
+// Title returns the item title.
+func (i docItem) Title() string {
+	switch {
+	case i.Segment != nil:
+		return i.Segment.Meta["title"]
+	case i.Checks != nil:
+		if t := i.Checks.Meta["title"]; t != "" {
+			return t
+		}
+		return "Checklist"
+	default:
+		return i.Form.Meta["title"]
+	}
+}
+
+// Anchor returns the item anchor ID.
+func (i docItem) Anchor() string {
+	return anchorID(append(i.Path[:len(i.Path):len(i.Path)], componentName(i.Component())))
+}
+
+// anchorID returns the anchor for a tree path, removing the file extension.
+func anchorID(parts []string) string {
+	id := strings.Join(parts, ".")
+	if l := len(parts); l != 0 {
+		id = strings.TrimSuffix(id, path.Ext(parts[l-1]))
+	}
+	return id
+}
+
+// formOption is a choice of a form item.
+type formOption struct {
+	Value, Label string
+}
+
+// formOptions returns the choices of a form item.
+func formOptions(i core.FormItem) []formOption {
+	list, _ := i.Meta["options"].([]interface{})
+	options := make([]formOption, 0, len(list))
+	for _, v := range list {
+		var o formOption
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			o.Value, o.Label = fmt.Sprint(v["value"]), fmt.Sprint(v["label"])
+		case map[string]interface{}:
+			o.Value, o.Label = fmt.Sprint(v["value"]), fmt.Sprint(v["label"])
+		case map[string]string:
+			o.Value, o.Label = v["value"], v["label"]
+		default:
+			o.Value = fmt.Sprint(v)
+		}
+		if o.Label == "" || o.Label == "<nil>" {
+			o.Label = o.Value
+		}
+		options = append(options, o)
+	}
+	return options
+}
+
+// formLines returns the number of lines of a form item.
+func formLines(i core.FormItem) int {
+	if l, ok := i.Meta["lines"].(int); ok {
+		return l
+	}
+	switch i.Type {
+	case "label":
+		return 0
+	case "text_area":
+		return 4
+	default:
+		return 1
+	}
+}
+
+// imageIndex contains the pictures of each language, by tree path.
+type imageIndex map[string]map[string]*core.Picture
+
+// newImageIndex collects the pictures of all the languages.
+func newImageIndex(root *core.Root) imageIndex {
+	x := make(imageIndex, len(root.Sub))
+	for i := range root.Sub {
+		lang := &root.Sub[i]
+		x[lang.ID] = make(map[string]*core.Picture)
+		x.add(lang.ID, nil, lang)
+	}
+	return x
+}
+
+func (x imageIndex) add(lang string, parts []string, c *core.Category) {
+	for _, cmp := range c.Components {
+		if p, ok := cmp.(*core.Picture); ok {
+			x[lang][path.Join(path.Join(parts...), path.Base(p.ID))] = p
+		}
+	}
+	for i := range c.Sub {
+		x.add(lang, append(parts[:len(parts):len(parts)], c.Sub[i].ID), &c.Sub[i])
+	}
+}
+
+// find returns the picture of the language in the tree path, falling back to
+// the one of the project language, or nil if not found.
+func (x imageIndex) find(lang string, parts []string, name string) *core.Picture {
+	key := path.Join(path.Join(parts...), path.Base(name))
+	if p := x[lang][key]; p != nil {
+		return p
+	}
+	return x[projectLang][key]
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/go-tent/tent/core"
@@ -17,38 +20,69 @@ var (
 	outDir  = os.Getenv("TENT_OUTDIR")
 	repoDir = os.Getenv("TENT_REPODIR")
 	branch  = os.Getenv("TENT_BRANCH")
+	locales = os.Getenv("TENT_LOCALES")
 	option  = struct {
 		SplitTools, SplitGlossary bool
 	}{true, false}
+	// gitIssues contains the conversion warnings of git-parse.
+	gitIssues = make(report)
 )
 
+// GitParse converts the languages in TENT_LOCALES, or all the ones of the
+// legacy repository, to the tent format. A language with errors is not
+// written.
 func GitParse() {
 	r, err := repo.Local(repoDir, branch)
 	if err != nil {
 		log.Fatalf("Repo error: %s", err)
 	}
 	r.Pull()
-	for _, loc := range []string{"en"} {
+	var list []string
+	for _, loc := range strings.Split(locales, ",") {
+		if loc = strings.TrimSpace(loc); loc != "" {
+			list = append(list, loc)
+		}
+	}
+	if len(list) == 0 {
+		list = r.Locale()
+		sort.Strings(list)
+	}
+	var (
+		failed int
+		issues = make(report)
+	)
+	for _, loc := range list {
+		if len(r.Categories(loc)) == 0 {
+			log.Println(loc, "language not found")
+			failed++
+			continue
+		}
 		root, err := CreateRoot(loc, r)
 		if err != nil {
-			log.Fatal(loc, err)
+			log.Println(loc, err)
+			failed++
+			continue
 		}
-		dir := path.Join(outDir, loc)
-		os.RemoveAll(path.Join(outDir, loc))
-		dst := destination.NewFile(dir)
-		prefix := make([]string, 0, 3)
-		for _, cat := range root.Sub {
-			prefix = append(prefix[:1], cat.ID)
-			WriteCat(dst, prefix, &cat)
-			for _, cat := range cat.Sub {
-				prefix = append(prefix[:2], cat.ID)
-				WriteCat(dst, prefix, &cat)
-				for _, cat := range cat.Sub {
-					prefix = append(prefix[:3], cat.ID)
-					WriteCat(dst, prefix, &cat)
-				}
-			}
+		for i := range root.Sub {
+			indexCategory(loc, []string{root.Sub[i].ID}, &root.Sub[i], issues)
+		}
+		if len(issues[loc]) != 0 {
+			log.Println(loc, "duplicates found")
+			failed++
+			continue
 		}
+		if err := writeTree(outDir, loc, root.Category); err != nil {
+			log.Println(loc, err)
+			failed++
+			continue
+		}
+		log.Printf("[%s] %d categories written", loc, len(root.Sub))
+	}
+	gitIssues.print("Conversion warnings")
+	issues.print("Duplicates")
+	printSavings()
+	if failed != 0 {
+		log.Fatalf("%d of %d language(s) failed", failed, len(list))
 	}
 }
 
@@ -58,6 +92,9 @@ var diffOrder = map[string]float64{
 
 func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 	root, _ := core.NewRoot(&core.Checks{}, &core.Form{})
+	links = make(map[string]struct{})
+	// The legacy repository has no translated difficulty titles.
+	untitled := make(map[string]bool)
 	for i, cname := range r.Categories(loc) {
 		c := r.Category(cname, loc)
 		cat := core.Category{
@@ -67,7 +104,7 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 				"title": c.Name,
 			},
 		}
-		addIcon(&cat, nil)
+		addIcon(loc, &cat, nil)
 		switch cat.ID {
 		case "glossary":
 			cat.Meta["template"] = "glossary"
@@ -91,6 +128,10 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 						"description": d.Descr,
 					},
 				}
+				if loc != "en" && !untitled[d.ID] {
+					gitIssues.add(loc, d.ID+"/", "difficulty title %q is not translated", diff.Meta["title"])
+					untitled[d.ID] = true
+				}
 				for _i, iname := range d.ItemNames() {
 					i := d.Item(iname)
 					if strings.HasSuffix(iname, "-0") || strings.HasSuffix(iname, "-1") {
@@ -110,7 +151,7 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 						seg.Index = 0
 					}
 					diff.Components = append(diff.Components, &seg)
-					diff.Components = append(diff.Components, getPics(r, i.ID, i.Body)...)
+					diff.Components = append(diff.Components, getPics(r, loc, i.ID, i.Body)...)
 				}
 				if c := d.Checks(); c != nil && len(c.Checks) != 0 {
 					var checks = core.Checks{ID: "checklist", Index: 100}
@@ -129,20 +170,33 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 				sub.Sub = append(sub.Sub, diff)
 			}
 			switch cat.ID {
+			case "about", "tools", "glossary":
+				if len(sub.Sub) == 0 {
+					return nil, fmt.Errorf("%s/%s: no difficulty", cat.ID, sub.ID)
+				}
+			}
+			switch cat.ID {
 			case "about":
 				cat.Components = append(cat.Components, sub.Sub[0].Components...)
 			case "tools":
 				if option.SplitTools {
-					splitTools(&cat, &sub)
+					if err := splitTools(&cat, &sub); err != nil {
+						return nil, err
+					}
 					break
 				}
-				v := sub.Sub[0].Components[0].(*core.Segment)
+				v, err := toolSegment(&sub)
+				if err != nil {
+					return nil, err
+				}
 				v.Meta = sub.Meta
 				v.Index = sub.Index
 				cat.Components = append(cat.Components, sub.Sub[0].Components...)
 			case "glossary":
 				if option.SplitGlossary {
-					splitGlossary(&cat, &sub)
+					if err := splitGlossary(&cat, &sub); err != nil {
+						return nil, err
+					}
 					break
 				}
 				idx := 1.0
@@ -151,9 +205,8 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 						s.Index = idx
 						idx++
 					}
-					cat.Components = append(cat.Components, sub.Sub[0].Components...)
-
 				}
+				cat.Components = append(cat.Components, sub.Sub[0].Components...)
 			default:
 				cat.Sub = append(cat.Sub, sub)
 			}
@@ -163,15 +216,29 @@ func CreateRoot(loc string, r *repo.Repo) (*core.Root, error) {
 	root.Sub = append(root.Sub, getForms(r, loc))
 	for l := range links {
 		if err := checkLink(root.Category, l); err != nil {
-			log.Printf("link: %s - %s", l, err)
+			gitIssues.add(loc, l, "%s", err)
 		}
 
 	}
 	return root, nil
 }
 
-func splitTools(cat, sub *core.Category) {
-	v := sub.Sub[0].Components[0].(*core.Segment)
+// toolSegment returns the segment of a tool, the first component of its
+// only difficulty.
+func toolSegment(sub *core.Category) (*core.Segment, error) {
+	if len(sub.Sub[0].Components) != 0 {
+		if v, ok := sub.Sub[0].Components[0].(*core.Segment); ok {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("tools/%s: no segment", sub.ID)
+}
+
+func splitTools(cat, sub *core.Category) error {
+	v, err := toolSegment(sub)
+	if err != nil {
+		return err
+	}
 	v.Meta = sub.Meta
 	v.Index = sub.Index
 	if len(cat.Sub) == 0 {
@@ -200,12 +267,13 @@ func splitTools(cat, sub *core.Category) {
 	case "android", "facebook":
 		idx = 5
 	default:
-		panic(v.ID)
+		return fmt.Errorf("tools/%s: unknown tool %q", sub.ID, v.ID)
 	}
 	cat.Sub[idx].Components = append(cat.Sub[idx].Components, sub.Sub[0].Components...)
+	return nil
 }
 
-func splitGlossary(cat, sub *core.Category) {
+func splitGlossary(cat, sub *core.Category) error {
 	cat.Sub = []core.Category{
 		{Index: 1, ID: "a-d", Meta: map[string]string{"title": "A-D"}},
 		{Index: 2, ID: "e-h", Meta: map[string]string{"title": "E-H"}},
@@ -215,7 +283,10 @@ func splitGlossary(cat, sub *core.Category) {
 		{Index: 6, ID: "u-z", Meta: map[string]string{"title": "U-Z"}},
 	}
 	for _, cmp := range sub.Sub[0].Components {
-		v := cmp.(*core.Segment)
+		v, ok := cmp.(*core.Segment)
+		if !ok || v.ID == "" {
+			return fmt.Errorf("glossary/%s: unexpected %s", sub.ID, componentName(cmp))
+		}
 		var idx int
 		switch {
 		case v.ID[0] >= 'a' && v.ID[0] <= 'd':
@@ -231,12 +302,12 @@ func splitGlossary(cat, sub *core.Category) {
 		case v.ID[0] >= 'u' && v.ID[0] <= 'z':
 			idx = 5
 		default:
-			panic(v.ID)
+			return fmt.Errorf("glossary/%s: no section for %q", sub.ID, v.ID)
 		}
 		v.Index = float64(len(cat.Sub[idx].Components) + 1)
 		cat.Sub[idx].Components = append(cat.Sub[idx].Components, v)
 	}
-
+	return nil
 }
 
 func getForms(r *repo.Repo, loc string) core.Category {
@@ -281,22 +352,22 @@ func getForms(r *repo.Repo, loc string) core.Category {
 	return cat
 }
 
-func addIcon(cat, parent *core.Category) {
+func addIcon(loc string, cat, parent *core.Category) {
 	p := cat.ID + ".png"
 	if parent != nil {
 		p = parent.ID + "_" + p
 	}
 	b, err := ioutil.ReadFile(path.Join("icons", p))
 	if err != nil {
-		log.Println("! icon not found", p)
+		gitIssues.add(loc, cat.ID+"/", "icon %q not found", p)
 		return
 	}
-	pic := core.Picture{ID: path.Base(p), Data: b}
+	pic := core.Picture{ID: path.Base(p), Data: optimizeImage(p, b)}
 	cat.Meta["icon"] = pic.ID
 	cat.Components = append(cat.Components, &pic)
 }
 
-func getPics(r *repo.Repo, id, contents string) []core.Component {
+func getPics(r *repo.Repo, loc, id, contents string) []core.Component {
 	var done = map[string]struct{}{}
 	var list []core.Component
 	for _, match := range imgFinder.FindAllStringSubmatch(contents, -1) {
@@ -307,38 +378,81 @@ func getPics(r *repo.Repo, id, contents string) []core.Component {
 		done[picName] = struct{}{}
 		ass := r.Asset(picName)
 		if ass == nil {
-			log.Println("! image not found", id, picName)
+			gitIssues.add(loc, id, "image %q not found", picName)
 			continue
 		}
-		list = append(list, &core.Picture{ID: picName, Data: []byte(ass.Content)})
+		list = append(list, &core.Picture{ID: picName, Data: optimizeImage(picName, []byte(ass.Content))})
 	}
 	if l := len(list); l != 0 || id == "signal-for-ios" {
 	}
 	return list
 }
 
-func WriteCat(dst destination.Destination, prefix []string, cat *core.Category) {
+// WriteCat writes the category and its components.
+func WriteCat(dst destination.Destination, prefix []string, cat *core.Category) error {
 	item, err := core.NewItem(prefix, cat)
 	if err != nil {
-		log.Fatal(prefix, err)
+		return fmt.Errorf("%s: %s", path.Join(prefix...), err)
 	}
 	if err := dst.Create(context.Background(), item); err != nil {
-		log.Fatal(prefix, item, err)
+		return fmt.Errorf("%s: %s", item.Name(), err)
 	}
-	count := .0
 	for _, cmp := range cat.Components {
-		if s, ok := cmp.(*core.Segment); ok && s.Index == 0 {
-			count++
-			s.Index = count
-		}
 		item, err := core.NewItem(prefix, cmp)
 		if err != nil {
-			log.Fatal(prefix, cmp, err)
+			return fmt.Errorf("%s: %s", path.Join(path.Join(prefix...), componentName(cmp)), err)
 		}
 		if err := dst.Create(context.Background(), item); err != nil {
-			if !os.IsExist(err) {
-				log.Fatal(prefix, item, cmp, err)
-			}
+			return fmt.Errorf("%s: %s", item.Name(), err)
+		}
+	}
+	return nil
+}
+
+// indexCategory prepares the category and its subcategories for WriteCat.
+// Components are sorted by name and the segments without index are numbered
+// in that order. A picture used by more items is kept once, any other
+// duplicate is reported.
+func indexCategory(lang string, parts []string, cat *core.Category, issues report) {
+	var (
+		list = cat.Components[:0]
+		seen = make(map[string]core.Component)
+	)
+	for _, cmp := range cat.Components {
+		name := componentName(cmp)
+		prev, ok := seen[name]
+		switch {
+		case !ok:
+			seen[name] = cmp
+			list = append(list, cmp)
+		case prev == cmp:
+		case isSamePicture(prev, cmp):
+		default:
+			issues.add(lang, path.Join(path.Join(parts...), name), "duplicate %s", componentKind(cmp))
 		}
 	}
+	cat.Components = list
+	sort.SliceStable(list, func(i, j int) bool { return componentName(list[i]) < componentName(list[j]) })
+	count := .0
+	for _, cmp := range list {
+		if s, ok := cmp.(*core.Segment); ok && s.Index == 0 {
+			count++
+			s.Index = count
+		}
+	}
+	var subs = make(map[string]bool)
+	for i := range cat.Sub {
+		sub := &cat.Sub[i]
+		if subs[sub.ID] {
+			issues.add(lang, path.Join(append(parts, sub.ID)...)+"/", "duplicate category")
+		}
+		subs[sub.ID] = true
+		indexCategory(lang, append(parts[:len(parts):len(parts)], sub.ID), sub, issues)
+	}
+}
+
+func isSamePicture(a, b core.Component) bool {
+	p, ok := a.(*core.Picture)
+	q, ok2 := b.(*core.Picture)
+	return ok && ok2 && bytes.Equal(p.Data, q.Data)
 }
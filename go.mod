@@ -5,8 +5,10 @@ go 1.12
 require (
 	github.com/fatih/color v1.7.0
 	github.com/go-tent/tent v0.0.0-20190411182200-27ce57f2d658
+	github.com/jung-kurt/gofpdf v1.16.2
 	github.com/mattn/go-colorable v0.0.9 // indirect
 	github.com/russross/blackfriday v2.0.0+incompatible
 	github.com/securityfirst/tent v0.0.0-20190331145917-2b28c9f2f9c3
+	golang.org/x/net v0.0.0-20190108225652-1e06a53dbb7e
 	gopkg.in/src-d/go-git.v4 v4.10.0
 )
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// htmlIssues contains the segments with HTML removed by sanitizeHTML.
+	htmlIssues = make(report)
+	// htmlAllowed contains the allowed elements, with their attributes.
+	htmlAllowed = map[string][]string{
+		"a": {"href"}, "img": {"src", "alt", "width", "height"},
+		"p": nil, "br": nil, "hr": nil, "div": nil, "span": nil,
+		"h1": nil, "h2": nil, "h3": nil, "h4": nil, "h5": nil, "h6": nil,
+		"em": nil, "strong": nil, "b": nil, "i": nil, "u": nil, "s": nil, "del": nil,
+		"bdi": nil, "sub": nil, "sup": nil, "abbr": nil, "kbd": nil, "code": nil, "pre": nil, "blockquote": nil,
+		"ul": nil, "ol": {"start"}, "li": nil, "dl": nil, "dt": nil, "dd": nil,
+		"table": nil, "thead": nil, "tbody": nil, "tr": nil,
+		"th": {"align", "colspan", "rowspan"}, "td": {"align", "colspan", "rowspan"},
+	}
+	// htmlCommonAttrs are allowed on every element.
+	htmlCommonAttrs = []string{"title", "lang", "dir"}
+	// htmlDropped contains the elements removed with their contents.
+	htmlDropped = map[string]bool{
+		"script": true, "style": true, "iframe": true, "frame": true, "object": true, "embed": true,
+		"noscript": true, "template": true, "svg": true, "math": true, "form": true, "select": true, "textarea": true,
+	}
+)
+
+// sanitizeHTML removes the elements, attributes and links that are not
+// allowed, returning the clean HTML and a description of what was removed.
+func sanitizeHTML(s string) (string, []string) {
+	var (
+		b       bytes.Buffer
+		removed = make(map[string]bool)
+		skip    int
+		z       = html.NewTokenizer(strings.NewReader(s))
+	)
+	for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+		t := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if htmlDropped[t.Data] {
+				removed[fmt.Sprintf("<%s>", t.Data)] = true
+				if tt == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			if skip != 0 {
+				continue
+			}
+			attrs, ok := htmlAllowed[t.Data]
+			if !ok {
+				removed[fmt.Sprintf("<%s>", t.Data)] = true
+				continue
+			}
+			t.Attr = filterAttrs(t, attrs, removed)
+			b.WriteString(t.String())
+		case html.EndTagToken:
+			if htmlDropped[t.Data] {
+				if skip != 0 {
+					skip--
+				}
+				continue
+			}
+			if _, ok := htmlAllowed[t.Data]; ok && skip == 0 {
+				b.WriteString(t.String())
+			}
+		case html.TextToken:
+			if skip == 0 {
+				b.WriteString(t.String())
+			}
+		}
+	}
+	list := make([]string, 0, len(removed))
+	for k := range removed {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return b.String(), list
+}
+
+// sanitizeXHTML is sanitizeHTML for XHTML documents: the result is parsed
+// again, to balance the elements, and written with self-closing void elements.
+func sanitizeXHTML(s string) (string, []string) {
+	clean, removed := sanitizeHTML(s)
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	nodes, err := html.ParseFragment(strings.NewReader(clean), body)
+	if err != nil {
+		return html.EscapeString(clean), append(removed, err.Error())
+	}
+	var b bytes.Buffer
+	for _, n := range nodes {
+		if err := html.Render(&b, n); err != nil {
+			return html.EscapeString(clean), append(removed, err.Error())
+		}
+	}
+	return b.String(), removed
+}
+
+// filterAttrs returns the allowed attributes of the token, with safe links.
+func filterAttrs(t html.Token, allowed []string, removed map[string]bool) []html.Attribute {
+	var attrs []html.Attribute
+	names := append(allowed[:len(allowed):len(allowed)], htmlCommonAttrs...)
+outer:
+	for _, a := range t.Attr {
+		for _, name := range names {
+			if a.Key != name || a.Namespace != "" {
+				continue
+			}
+			if (name == "href" || name == "src") && !safeURL(t.Data, a.Val) {
+				removed[fmt.Sprintf("%s %q on <%s>", name, a.Val, t.Data)] = true
+				continue outer
+			}
+			attrs = append(attrs, a)
+			continue outer
+		}
+		removed[fmt.Sprintf("%s attribute on <%s>", a.Key, t.Data)] = true
+	}
+	return attrs
+}
+
+// safeURL returns true for relative links, web, mail and umbrella links, and
+// for images embedded in an img element.
+func safeURL(tag, v string) bool {
+	u := strings.ToLower(strings.TrimSpace(v))
+	if tag == "img" && strings.HasPrefix(u, "data:image/") {
+		return true
+	}
+	i := strings.IndexAny(u, ":/?#")
+	if i == -1 || u[i] != ':' {
+		return true
+	}
+	switch u[:i] {
+	case "http", "https", "mailto", strings.TrimSuffix(linkPrefix, "://"):
+		return true
+	}
+	return false
+}
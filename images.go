@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// imageTypes contains the supported image types and their extension.
+var imageTypes = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/svg+xml": ".svg",
+	"image/webp":    ".webp",
+}
+
+var (
+	// imgOptimize enables the re-encoding of PNG and JPEG images.
+	imgOptimize = os.Getenv("IMG_OPTIMIZE") != ""
+	// imgMaxDim is the maximum width or height of an optimized image, 0 is no limit.
+	imgMaxDim = envInt("IMG_MAX_DIM", 0)
+	// imgQuality is the quality of the re-encoded JPEG images.
+	imgQuality = envInt("IMG_JPEG_QUALITY", 85)
+	// imgSavings contains the sizes before and after the optimization.
+	imgSavings struct{ Count, Before, After int }
+)
+
+// envInt returns the integer value of the variable, or def if not set.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+	return n
+}
+
+// imageType returns the media type of the image, sniffing its contents, or
+// an empty string if the type is not supported.
+func imageType(b []byte) string {
+	t := http.DetectContentType(b)
+	if _, ok := imageTypes[t]; ok {
+		return t
+	}
+	head := b
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if strings.HasPrefix(t, "text/") && bytes.Contains(head, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	return ""
+}
+
+// optimizeImage re-encodes a PNG or JPEG image, if IMG_OPTIMIZE is set,
+// stripping its metadata and resizing it to IMG_MAX_DIM. The original is
+// returned if it has no metadata, the result is not smaller and the image was
+// not resized.
+func optimizeImage(name string, b []byte) []byte {
+	if !imgOptimize {
+		return b
+	}
+	kind := imageType(b)
+	if kind != "image/png" && kind != "image/jpeg" {
+		return b
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		log.Println("! invalid image", name, err)
+		return b
+	}
+	var resized bool
+	if w, h := img.Bounds().Dx(), img.Bounds().Dy(); imgMaxDim > 0 && (w > imgMaxDim || h > imgMaxDim) {
+		if w > h {
+			w, h = imgMaxDim, max(h*imgMaxDim/w, 1)
+		} else {
+			w, h = max(w*imgMaxDim/h, 1), imgMaxDim
+		}
+		img, resized = resizeImage(img, w, h), true
+	}
+	buf := bytes.NewBuffer(nil)
+	if kind == "image/png" {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(buf, img)
+	} else {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: imgQuality})
+	}
+	if err != nil {
+		log.Println("! cannot encode image", name, err)
+		return b
+	}
+	meta := hasMetadata(kind, b)
+	if !resized && !meta && buf.Len() >= len(b) {
+		return b
+	}
+	imgSavings.Count++
+	imgSavings.Before += len(b)
+	imgSavings.After += buf.Len()
+	var note string
+	if meta {
+		note = ", metadata stripped"
+	}
+	log.Printf("image %s: %d -> %d bytes (%+.1f%%%s)", name, len(b), buf.Len(), -savedPercent(len(b), buf.Len()), note)
+	return buf.Bytes()
+}
+
+// hasMetadata returns true if the image contains text, EXIF or other
+// application data: PNG text, eXIf and tIME chunks, JPEG APP1-APP15 and
+// comment segments.
+func hasMetadata(kind string, b []byte) bool {
+	switch kind {
+	case "image/png":
+		for i := 8; i+8 <= len(b); {
+			n := int(binary.BigEndian.Uint32(b[i:]))
+			switch string(b[i+4 : i+8]) {
+			case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+				return true
+			case "IEND":
+				return false
+			}
+			i += n + 12
+		}
+	case "image/jpeg":
+		for i := 2; i+4 <= len(b) && b[i] == 0xff; {
+			switch m := b[i+1]; {
+			case m == 0xda:
+				return false
+			case m >= 0xe1 && m <= 0xef, m == 0xfe:
+				return true
+			}
+			i += int(binary.BigEndian.Uint16(b[i+2:])) + 2
+		}
+	}
+	return false
+}
+
+// printSavings logs the total size saved by the optimization.
+func printSavings() {
+	if imgSavings.Count == 0 {
+		return
+	}
+	s := imgSavings
+	log.Printf("*** Images ***\n%d optimized: %d -> %d bytes (%+.1f%%)", s.Count, s.Before, s.After, -savedPercent(s.Before, s.After))
+}
+
+func savedPercent(before, after int) float64 {
+	return float64(before-after) * 100 / float64(before)
+}
+
+// resizeImage scales the image to the given size, averaging the source
+// pixels that fall in each pixel of the result.
+func resizeImage(src image.Image, w, h int) image.Image {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+(y+1)*b.Dy()/h
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+(x+1)*b.Dx()/w
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
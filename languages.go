@@ -0,0 +1,62 @@
+package main
+
+import "strings"
+
+// langInfo contains the metadata of a language.
+type langInfo struct {
+	// Name is the native name of the language.
+	Name string
+	// Script is the ISO 15924 code of the writing system.
+	Script string
+	// RTL is true for scripts written right to left.
+	RTL bool
+}
+
+// Dir returns the direction of the language, for the dir attribute.
+func (l langInfo) Dir() string {
+	if l.RTL {
+		return "rtl"
+	}
+	return "ltr"
+}
+
+// languages is the registry of the known languages, by locale.
+var languages = map[string]langInfo{
+	"ar":    {"العربية", "Arab", true},
+	"bn":    {"বাংলা", "Beng", false},
+	"ckb":   {"کوردی", "Arab", true},
+	"de":    {"Deutsch", "Latn", false},
+	"en":    {"English", "Latn", false},
+	"es":    {"Español", "Latn", false},
+	"fa":    {"فارسی", "Arab", true},
+	"fr":    {"Français", "Latn", false},
+	"he":    {"עברית", "Hebr", true},
+	"hi":    {"हिन्दी", "Deva", false},
+	"id":    {"Bahasa Indonesia", "Latn", false},
+	"my":    {"မြန်မာ", "Mymr", false},
+	"ps":    {"پښتو", "Arab", true},
+	"pt":    {"Português", "Latn", false},
+	"ru":    {"Русский", "Cyrl", false},
+	"sw":    {"Kiswahili", "Latn", false},
+	"th":    {"ไทย", "Thai", false},
+	"tr":    {"Türkçe", "Latn", false},
+	"uk":    {"Українська", "Cyrl", false},
+	"ur":    {"اردو", "Arab", true},
+	"vi":    {"Tiếng Việt", "Latn", false},
+	"zh":    {"中文", "Hans", false},
+	"zh_TW": {"中文（台灣）", "Hant", false},
+}
+
+// langMeta returns the metadata of a locale, falling back to its language
+// and then to a left to right language named after the locale.
+func langMeta(id string) langInfo {
+	if l, ok := languages[id]; ok {
+		return l
+	}
+	if i := strings.IndexAny(id, "_-"); i != -1 {
+		if l, ok := languages[id[:i]]; ok {
+			return l
+		}
+	}
+	return langInfo{Name: id, Script: "Latn"}
+}
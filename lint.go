@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+)
+
+// lintLevels are the severity levels, from the lowest.
+var lintLevels = map[string]int{"info": 0, "warning": 1, "error": 2}
+
+// lintRule checks a language tree, calling report for each problem.
+type lintRule struct {
+	Name     string
+	Severity string
+	Check    func(root *core.Root, lang *core.Category, report func(name, format string, args ...interface{}))
+}
+
+var lintRules = []lintRule{
+	{"category-title", "error", lintCategoryTitle},
+	{"category-icon", "warning", lintCategoryIcon},
+	{"difficulty", "error", lintDifficulty},
+	{"segment-index", "warning", lintSegmentIndex},
+	{"empty-content", "error", lintEmptyContent},
+	{"missing-image", "error", lintMissingImage},
+	{"orphan-image", "warning", lintOrphanImage},
+	{"unknown-id", "warning", lintUnknownID},
+}
+
+// Lint checks the tree with the rules in LINT_RULES, or all of them, and
+// reports the problems from LINT_LEVEL up. LINT_SEVERITY changes the level
+// of the rules, as a list of rule=level. It exits with an error status if
+// any error is found.
+func Lint() {
+	rules, err := newLinter(os.Getenv("LINT_RULES"), os.Getenv("LINT_SEVERITY"))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	level, ok := lintLevels[os.Getenv("LINT_LEVEL")]
+	if !ok && os.Getenv("LINT_LEVEL") != "" {
+		log.Fatalln("unknown level:", os.Getenv("LINT_LEVEL"))
+	}
+	src, err := getSource()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root, err := decodeRoot(src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	issues, count := rules.run(root, level)
+	issues.print("Lint")
+	if count["error"] != 0 {
+		log.Printf("%d error(s), %d warning(s)", count["error"], count["warning"])
+		os.Exit(1)
+	}
+}
+
+// linter is a list of rules, with their severity.
+type linter []lintRule
+
+// newLinter returns the rules with the given names, in their default order,
+// with the given severity. An empty list returns all the rules.
+func newLinter(names, severity string) (linter, error) {
+	var (
+		l      linter
+		chosen = make(map[string]bool)
+		levels = make(map[string]string)
+	)
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			chosen[n] = true
+		}
+	}
+	for _, v := range strings.Split(severity, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		p := strings.SplitN(v, "=", 2)
+		if _, ok := lintLevels[p[len(p)-1]]; len(p) != 2 || !ok {
+			return nil, fmt.Errorf("invalid severity %q", v)
+		}
+		levels[p[0]] = p[1]
+	}
+	for _, r := range lintRules {
+		if len(chosen) != 0 && !chosen[r.Name] {
+			delete(levels, r.Name)
+			continue
+		}
+		if s, ok := levels[r.Name]; ok {
+			r.Severity = s
+		}
+		l = append(l, r)
+		delete(chosen, r.Name)
+		delete(levels, r.Name)
+	}
+	for n := range chosen {
+		return nil, fmt.Errorf("unknown rule %q", n)
+	}
+	for n := range levels {
+		return nil, fmt.Errorf("unknown rule %q", n)
+	}
+	return l, nil
+}
+
+// run checks all languages, returning the problems from the given level and
+// their count by severity.
+func (l linter) run(root *core.Root, level int) (report, map[string]int) {
+	var (
+		issues = make(report)
+		count  = make(map[string]int)
+	)
+	for i := range root.Sub {
+		lang := &root.Sub[i]
+		for _, r := range l {
+			r.Check(root, lang, func(name, format string, args ...interface{}) {
+				if lintLevels[r.Severity] < level {
+					return
+				}
+				count[r.Severity]++
+				issues.add(lang.ID, name, "%s %s: %s", r.Severity, r.Name, fmt.Sprintf(format, args...))
+			})
+		}
+	}
+	return issues, count
+}
+
+// walkTree calls fn for all the categories under the language, with their path.
+func walkTree(lang *core.Category, fn func(parts []string, cat *core.Category)) {
+	var walk func(parts []string, cat *core.Category)
+	walk = func(parts []string, cat *core.Category) {
+		for i := range cat.Sub {
+			p := append(parts[:len(parts):len(parts)], cat.Sub[i].ID)
+			fn(p, &cat.Sub[i])
+			walk(p, &cat.Sub[i])
+		}
+	}
+	walk(nil, lang)
+}
+
+func lintCategoryTitle(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		if strings.TrimSpace(cat.Meta["title"]) == "" {
+			report(path.Join(parts...)+"/", "missing title")
+		}
+	})
+}
+
+// lintCategoryIcon checks that top categories have an existing icon.
+func lintCategoryIcon(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	for i := range lang.Sub {
+		cat := &lang.Sub[i]
+		icon := cat.Meta["icon"]
+		switch {
+		case icon == "":
+			report(cat.ID+"/", "missing icon")
+		case findComponent(cat, icon) == nil:
+			report(cat.ID+"/", "icon %q not found", icon)
+		}
+	}
+}
+
+// lintDifficulty checks the names of the third level categories.
+func lintDifficulty(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		if _, ok := diffOrder[cat.ID]; len(parts) == 3 && !ok {
+			report(path.Join(parts...)+"/", "unknown difficulty %q", cat.ID)
+		}
+	})
+}
+
+// lintSegmentIndex checks that the segment indexes of a category are 1 to n,
+// unless they're all 0.
+func lintSegmentIndex(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		var (
+			list []float64
+			seen = make(map[float64]string)
+		)
+		for _, cmp := range cat.Components {
+			s, ok := cmp.(*core.Segment)
+			if !ok {
+				continue
+			}
+			if other, ok := seen[s.Index]; ok && s.Index != 0 {
+				report(path.Join(path.Join(parts...), componentName(s)), "index %v already used by %s", s.Index, other)
+			}
+			seen[s.Index] = componentName(s)
+			list = append(list, s.Index)
+		}
+		sort.Float64s(list)
+		if len(list) == 0 || list[len(list)-1] == 0 {
+			return
+		}
+		for i, v := range list {
+			if v != float64(i+1) {
+				report(path.Join(parts...)+"/", "segment indexes are not contiguous: %v", list)
+				return
+			}
+		}
+	})
+}
+
+func lintEmptyContent(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		for _, cmp := range cat.Components {
+			name := path.Join(path.Join(parts...), componentName(cmp))
+			switch v := cmp.(type) {
+			case *core.Segment:
+				if len(bytes.TrimSpace(v.Body)) == 0 {
+					report(name, "empty body")
+				}
+			case *core.Checks:
+				if len(v.List) == 0 {
+					report(name, "empty checklist")
+				}
+			case *core.Form:
+				if len(v.Screens) == 0 {
+					report(name, "form without screens")
+				}
+			}
+		}
+	})
+}
+
+// lintMissingImage checks that the images of the segments are in their
+// category, or in the same category of the project language.
+func lintMissingImage(root *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	source := findCategory(root.Category, []string{projectLang})
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		var fallback *core.Category
+		if source != nil {
+			fallback = findCategory(source, parts)
+		}
+		for _, cmp := range cat.Components {
+			s, ok := cmp.(*core.Segment)
+			if !ok {
+				continue
+			}
+			for _, m := range imgFinder.FindAllSubmatch(s.Body, -1) {
+				if (docNode{Cat: cat}).Picture(string(m[1])) != nil {
+					continue
+				}
+				if fallback == nil || (docNode{Cat: fallback}).Picture(string(m[1])) == nil {
+					report(path.Join(path.Join(parts...), componentName(s)), "image %q not found", m[1])
+				}
+			}
+		}
+	})
+}
+
+// lintOrphanImage checks that the pictures are used by a segment or as icon.
+func lintOrphanImage(_ *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		used := map[string]bool{cat.Meta["icon"]: true}
+		for _, cmp := range cat.Components {
+			if s, ok := cmp.(*core.Segment); ok {
+				for _, m := range imgFinder.FindAllSubmatch(s.Body, -1) {
+					used[path.Base(string(m[1]))] = true
+				}
+			}
+		}
+		for _, cmp := range cat.Components {
+			if p, ok := cmp.(*core.Picture); ok && !used[path.Base(p.ID)] {
+				report(path.Join(path.Join(parts...), componentName(p)), "picture not used")
+			}
+		}
+	})
+}
+
+// lintUnknownID checks that the categories and components of a translation
+// exist in the project language.
+func lintUnknownID(root *core.Root, lang *core.Category, report func(string, string, ...interface{})) {
+	source := findCategory(root.Category, []string{projectLang})
+	if source == nil || source == lang {
+		return
+	}
+	walkTree(lang, func(parts []string, cat *core.Category) {
+		src := findCategory(source, parts)
+		if src == nil {
+			report(path.Join(parts...)+"/", "category not in %s", projectLang)
+			return
+		}
+		for _, cmp := range cat.Components {
+			if findComponent(src, componentName(cmp)) == nil {
+				report(path.Join(path.Join(parts...), componentName(cmp)), "component not in %s", projectLang)
+			}
+		}
+	})
+}
@@ -8,8 +8,10 @@ import (
 	"os"
 	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/go-tent/tent/core"
 	"github.com/go-tent/tent/source"
@@ -28,6 +30,7 @@ var (
 	projectLang string
 	imgFinder   = regexp.MustCompile(`!\[[^\)]*\]\s*\(([^\)]+)\)`)
 	linkFinder  = regexp.MustCompile(linkPrefix + `[^)]*`)
+	prefixFixer = regexp.MustCompile(`umbrella\s*:\s*/\s*/`)
 )
 
 var (
@@ -47,8 +50,16 @@ func init() {
 
 func main() {
 	var options = map[string]func(){
+		"assets":             AssetReport,
+		"consistency":        Consistency,
+		"diff":               Diff,
 		"git-parse":          GitParse,
+		"lint":               Lint,
+		"make-epub":          MakeEPUB,
 		"make-html":          MakeHTML,
+		"make-pdf":           MakePDF,
+		"make-review":        MakeReview,
+		"stats":              Stats,
 		"transifex-legacy":   TransifexLegacy,
 		"transifex-upload":   TransifexUpload,
 		"transifex-download": TransifexDownload,
@@ -75,15 +86,30 @@ func makeSlug(s string) string {
 	}, s)
 }
 
-var commit *object.Commit
+var (
+	project *git.Repository
+	commit  *object.Commit
+)
 
-func getSource() (source.Source, error) {
-	if commit == nil {
+// cloneProject returns the repository of PROJECT_URL, cloning it only once.
+func cloneProject() (*git.Repository, error) {
+	if project == nil {
 		log.Println("Cloning...")
 		r, err := git.Clone(memory.NewStorage(), nil, &git.CloneOptions{URL: os.Getenv("PROJECT_URL")})
 		if err != nil {
 			return nil, err
 		}
+		project = r
+	}
+	return project, nil
+}
+
+func getSource() (source.Source, error) {
+	if commit == nil {
+		r, err := cloneProject()
+		if err != nil {
+			return nil, err
+		}
 		hash, err := r.Reference(plumbing.ReferenceName("refs/remotes/origin/master"), false)
 		if err != nil {
 			return nil, err
@@ -96,6 +122,37 @@ func getSource() (source.Source, error) {
 	return source.NewGit(context.Background(), commit)
 }
 
+// getRevision returns the Source of a revision of PROJECT_URL, like a commit
+// hash, a tag or a remote branch.
+func getRevision(rev string) (source.Source, error) {
+	r, err := cloneProject()
+	if err != nil {
+		return nil, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", rev, err)
+	}
+	c, err := r.CommitObject(*hash)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Parsing", rev)
+	return source.NewGit(context.Background(), c)
+}
+
+// decodeRoot returns the tree of the Source.
+func decodeRoot(src source.Source) (*core.Root, error) {
+	root, err := core.NewRoot(core.Components...)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.Decode(src); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
 var links = make(map[string]struct{})
 
 func checkLink(root *core.Category, link string) error {
@@ -103,7 +160,7 @@ func checkLink(root *core.Category, link string) error {
 	cat := root
 	for _, p := range parts {
 		if path.Ext(p) != "" {
-			return nil
+			return checkComponent(cat, p)
 		}
 		var c *core.Category
 		list := make([]string, len(cat.Sub))
@@ -122,6 +179,157 @@ func checkLink(root *core.Category, link string) error {
 	return nil
 }
 
+// findCategory returns the Category at the given path, or nil if not found.
+func findCategory(root *core.Category, parts []string) *core.Category {
+	cat := root
+next:
+	for _, p := range parts {
+		for i := range cat.Sub {
+			if cat.Sub[i].ID == p {
+				cat = &cat.Sub[i]
+				continue next
+			}
+		}
+		return nil
+	}
+	return cat
+}
+
+func checkComponent(cat *core.Category, name string) error {
+	for _, cmp := range cat.Components {
+		if componentName(cmp) == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("file not found: %s in %s", name, cat.ID)
+}
+
+// componentName returns the file name used for the Component in the tree.
+func componentName(cmp core.Component) string {
+	name := cmp.GetID()
+	if pre, exts := cmp.Format(); len(exts) == 1 {
+		name = pre + name + exts[0]
+	}
+	return name
+}
+
+// linkDamage looks for changes made by translators to an umbrella link.
+func linkDamage(link string) (problems []string) {
+	p := strings.TrimPrefix(link, linkPrefix)
+	if strings.IndexFunc(p, unicode.IsSpace) != -1 {
+		problems = append(problems, "contains spaces")
+	}
+	if strings.IndexFunc(p, isBidi) != -1 {
+		problems = append(problems, "contains direction markers")
+	}
+	for _, s := range strings.Split(p, "/") {
+		if strings.IndexFunc(s, func(r rune) bool { return r > unicode.MaxASCII && !isBidi(r) }) != -1 {
+			problems = append(problems, fmt.Sprintf("translated segment %q", s))
+		}
+	}
+	return problems
+}
+
+// isBidi returns true for the invisible characters that control text direction.
+func isBidi(r rune) bool {
+	switch {
+	case r == '\u061c', r == '\u200e', r == '\u200f':
+		return true
+	case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+		return true
+	}
+	return false
+}
+
+// langLinks contains the files using each link, by language.
+type langLinks map[string]map[string][]string
+
+// add collects all links in contents.
+func (l langLinks) add(lang, name, contents string) {
+	if l[lang] == nil {
+		l[lang] = make(map[string][]string)
+	}
+	for _, p := range prefixFixer.FindAllString(contents, -1) {
+		if p != linkPrefix {
+			l[lang][p] = append(l[lang][p], name)
+		}
+	}
+	for _, link := range linkFinder.FindAllString(contents, -1) {
+		l[lang][link] = append(l[lang][link], name)
+	}
+}
+
+// check verifies each link against the tree of its own language.
+func (l langLinks) check(root *core.Category) report {
+	var r = make(report)
+	for lang, links := range l {
+		var tree *core.Category
+		for i := range root.Sub {
+			if root.Sub[i].ID == lang {
+				tree = &root.Sub[i]
+				break
+			}
+		}
+		for link, files := range links {
+			var problems []string
+			if !strings.HasPrefix(link, linkPrefix) {
+				problems = append(problems, "damaged prefix")
+			} else {
+				problems = linkDamage(link)
+				if tree == nil {
+					problems = append(problems, "language not found")
+				} else if err := checkLink(tree, link); err != nil {
+					problems = append(problems, err.Error())
+				}
+			}
+			for _, f := range files {
+				for _, p := range problems {
+					r.add(lang, f, "%q %s", link, p)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// report collects messages by language and file.
+type report map[string]map[string][]string
+
+func (r report) add(lang, name, format string, args ...interface{}) {
+	if r[lang] == nil {
+		r[lang] = make(map[string][]string)
+	}
+	r[lang][name] = append(r[lang][name], fmt.Sprintf(format, args...))
+}
+
+// print logs all messages, sorted by language and file.
+func (r report) print(title string) {
+	if len(r) == 0 {
+		return
+	}
+	log.Printf("*** %s ***", title)
+	langs := make([]string, 0, len(r))
+	for lang := range r {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	for _, lang := range langs {
+		names := make([]string, 0, len(r[lang]))
+		for name := range r[lang] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			msgs := r[lang][name]
+			sort.Strings(msgs)
+			for _, m := range msgs {
+				log.Printf("[%s] %s: %s", lang, name, m)
+			}
+		}
+		log.Printf("[%s] %d file(s) with issues.", lang, len(names))
+	}
+}
+
 func replaceLinks(s string) string {
 	v, ok := linkFix[strings.ReplaceAll(s, " ", "")[len(linkPrefix):]]
 	if ok {
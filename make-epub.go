@@ -0,0 +1,335 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-tent/tent/core"
+	"github.com/russross/blackfriday"
+)
+
+var (
+	epubOut   = os.Getenv("EPUB_OUTDIR")
+	epubTitle = os.Getenv("EPUB_TITLE")
+)
+
+func MakeEPUB() {
+	src, err := getSource()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root, err := decodeRoot(src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	images := newImageIndex(root)
+	for _, loc := range strings.Split(os.Getenv("EPUB_LANGS"), ",") {
+		for i := range root.Sub {
+			lang := &root.Sub[i]
+			if lang.ID != loc {
+				continue
+			}
+			if err := writeEPUB(filepath.Join(epubOut, loc+".epub"), lang, images); err != nil {
+				log.Println(loc, err)
+			}
+		}
+	}
+	htmlIssues.print("Unsafe HTML")
+}
+
+// epubItem is an element of the package manifest.
+type epubItem struct {
+	ID, Href, Type string
+}
+
+// epubChapter contains the data for the chapter template.
+type epubChapter struct {
+	Lang, Dir string
+	Node      docNode
+}
+
+type epubWriter struct {
+	Lang, Dir, Title string
+	Nodes            []docNode
+	Chapters         []epubItem
+	Images           []epubItem
+	Modified         string
+
+	lang     string
+	images   imageIndex
+	tmpl     *template.Template
+	files    map[string]string
+	pictures map[string][]byte
+}
+
+// writeEPUB creates an EPUB3 book for the language, with a chapter for each
+// top category.
+func writeEPUB(name string, lang *core.Category, images imageIndex) error {
+	w := epubWriter{
+		lang:     lang.ID,
+		images:   images,
+		Lang:     strings.Replace(lang.ID, "_", "-", -1),
+		Dir:      langMeta(lang.ID).Dir(),
+		Title:    epubTitle,
+		Nodes:    newDoc(lang),
+		Modified: time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		files:    make(map[string]string),
+		pictures: make(map[string][]byte),
+	}
+	if w.Title == "" {
+		w.Title = "Umbrella"
+	}
+	if t := lang.Meta["title"]; t != "" {
+		w.Title += " - " + t
+	}
+	t, err := template.New("epub").Funcs(template.FuncMap{
+		"xml":      xmlText,
+		"heading":  heading,
+		"markdown": w.markdown,
+		"href":     w.href,
+	}).Parse(epubTemplate)
+	if err != nil {
+		return err
+	}
+	w.tmpl = t
+	for i, n := range w.Nodes {
+		c := epubItem{ID: fmt.Sprintf("chapter-%02d", i+1), Type: "application/xhtml+xml"}
+		c.Href = c.ID + ".xhtml"
+		w.Chapters = append(w.Chapters, c)
+		w.addFiles(n, c.Href)
+	}
+
+	b := bytes.NewBuffer(nil)
+	z := zip.NewWriter(b)
+	f, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte("application/epub+zip")); err != nil {
+		return err
+	}
+	if err := w.execute(z, "META-INF/container.xml", "container", w); err != nil {
+		return err
+	}
+	for i, c := range w.Chapters {
+		if err := w.execute(z, "OEBPS/"+c.Href, "chapter", epubChapter{w.Lang, w.Dir, w.Nodes[i]}); err != nil {
+			return err
+		}
+	}
+	for _, img := range w.Images {
+		f, err := z.Create("OEBPS/" + img.Href)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(w.pictures[img.Href]); err != nil {
+			return err
+		}
+	}
+	for _, v := range [][2]string{{"nav.xhtml", "nav"}, {"style.css", "style"}, {"content.opf", "package"}} {
+		if err := w.execute(z, "OEBPS/"+v[0], v[1], w); err != nil {
+			return err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	return writeFile(name, b.Bytes())
+}
+
+func (w *epubWriter) addFiles(n docNode, file string) {
+	w.files[n.Anchor()] = file
+	for _, i := range n.Items() {
+		w.files[i.Anchor()] = file
+	}
+	for _, s := range n.Sub {
+		w.addFiles(s, file)
+	}
+}
+
+func (w *epubWriter) execute(z *zip.Writer, name, tmpl string, data interface{}) error {
+	f, err := z.Create(name)
+	if err != nil {
+		return err
+	}
+	return w.tmpl.ExecuteTemplate(f, tmpl, data)
+}
+
+// href returns the link to an anchor, in its chapter.
+func (w *epubWriter) href(anchor string) string {
+	return w.files[anchor] + "#" + anchor
+}
+
+// markdown renders the segment of the item as XHTML, adding its images to the
+// manifest.
+func (w *epubWriter) markdown(n docNode, i docItem) string {
+	body := imgFinder.ReplaceAllFunc(i.Segment.Body, func(b []byte) []byte {
+		name := string(b[bytes.Index(b, []byte{'('})+1 : len(b)-1])
+		pic := w.images.find(w.lang, n.Path, name)
+		if pic == nil {
+			log.Println("Cannot find", name, "in", n.Path)
+			return b
+		}
+		kind := imageType(pic.Data)
+		if kind == "" {
+			log.Println("Unsupported image", name, "in", n.Path)
+			return b
+		}
+		href := "images/" + n.Anchor() + "-" + path.Base(pic.ID)
+		if _, ok := w.pictures[href]; !ok {
+			w.pictures[href] = pic.Data
+			w.Images = append(w.Images, epubItem{
+				ID:   fmt.Sprintf("image-%d", len(w.Images)+1),
+				Href: href,
+				Type: kind,
+			})
+		}
+		return []byte("![" + path.Base(pic.ID) + "](" + href + ")")
+	})
+	body = linkFinder.ReplaceAllFunc(body, func(b []byte) []byte {
+		anchor := anchorID(strings.Split(strings.Trim(strings.TrimPrefix(string(b), linkPrefix), "/"), "/"))
+		if _, ok := w.files[anchor]; !ok {
+			log.Println("Cannot resolve", string(b), "in", n.Path)
+			return b
+		}
+		return []byte(w.href(anchor))
+	})
+	clean, removed := sanitizeXHTML(string(blackfriday.Run(body, blackfriday.WithRenderer(newHTMLRenderer(blackfriday.UseXHTML)))))
+	for _, r := range removed {
+		htmlIssues.add(w.lang, path.Join(path.Join(i.Path...), componentName(i.Segment)), "removed %s", r)
+	}
+	return clean
+}
+
+// xmlText returns the escaped text of a value, nil is empty.
+func xmlText(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return html.EscapeString(fmt.Sprint(v))
+}
+
+// writeFile creates a file, with its directory.
+func writeFile(name string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(b)
+	return err
+}
+
+const epubTemplate = `
+{{- define "container" -}}
+<?xml version="1.0" encoding="utf-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+{{end}}
+
+{{- define "package" -}}
+<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid" xml:lang="{{.Lang}}" dir="{{.Dir}}">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="uid">urn:umbrella:{{xml .Lang}}</dc:identifier>
+<dc:title>{{xml .Title}}</dc:title>
+<dc:language>{{xml .Lang}}</dc:language>
+<meta property="dcterms:modified">{{.Modified}}</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="style" href="style.css" media-type="text/css"/>
+{{range .Chapters}}<item id="{{.ID}}" href="{{.Href}}" media-type="{{.Type}}"/>
+{{end}}{{range .Images}}<item id="{{.ID}}" href="{{.Href}}" media-type="{{.Type}}"/>
+{{end -}}
+</manifest>
+<spine page-progression-direction="{{.Dir}}">
+{{range .Chapters}}<itemref idref="{{.ID}}"/>
+{{end -}}
+</spine>
+</package>
+{{end}}
+
+{{- define "nav" -}}
+<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Lang}}" xml:lang="{{.Lang}}" dir="{{.Dir}}">
+<head><meta charset="utf-8"/><title>{{xml .Title}}</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>{{xml .Title}}</h1>
+<ol>{{range .Nodes}}{{template "nav-node" .}}{{end}}</ol>
+</nav>
+</body>
+</html>
+{{end}}
+
+{{- define "nav-node"}}<li><a href="{{href .Anchor}}">{{xml .Title}}</a>
+{{- if or .Sub .Entries}}<ol>
+{{- range .Sub}}{{template "nav-node" .}}{{end}}
+{{- range .Entries}}<li><a href="{{href .Anchor}}">{{xml .Title}}</a></li>{{end -}}
+</ol>{{end -}}
+</li>{{end}}
+
+{{- define "style" -}}
+body { font-family: serif; line-height: 1.4; }
+.description { font-style: italic; }
+ul.checklist { list-style: none; }
+ul, ol { padding-inline-start: 2em; padding-inline-end: 0; }
+img { max-width: 100%; }
+{{end}}
+
+{{- define "chapter" -}}
+<?xml version="1.0" encoding="utf-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="{{.Lang}}" xml:lang="{{.Lang}}" dir="{{.Dir}}">
+<head><meta charset="utf-8"/><title>{{xml .Node.Title}}</title><link rel="stylesheet" type="text/css" href="style.css"/></head>
+<body>
+{{template "node" .Node}}
+</body>
+</html>
+{{end}}
+
+{{- define "node"}}
+<section id="{{.Anchor}}">
+{{heading .Depth .Title}}
+{{with .Description}}<p class="description">{{xml .}}</p>{{end}}
+{{- range $item := .Items}}
+{{with .Segment}}<section id="{{$item.Anchor}}" epub:type="subchapter">
+<h4>{{xml $item.Title}}</h4>
+{{markdown $ $item}}
+</section>{{end}}
+{{with .Checks}}<section id="{{$item.Anchor}}">
+<h4>{{xml $item.Title}}</h4>
+<ul class="checklist">{{range .List}}{{template "check" .}}{{end}}</ul>
+</section>{{end}}
+{{with .Form}}<section id="{{$item.Anchor}}">
+<h4>{{xml $item.Title}}</h4>
+{{range .Screens}}<h5>{{xml (index .Meta "title")}}</h5>
+<dl>{{range .Items}}<dt>{{xml (index .Meta "label")}}</dt>{{with index .Meta "hint"}}<dd>{{xml .}}</dd>{{end}}{{end}}</dl>
+{{end}}
+</section>{{end}}
+{{- end}}
+{{range .Sub}}{{template "node" .}}{{end}}
+</section>
+{{end}}
+
+{{- define "check"}}<li>
+{{- if .Check}}&#x2610; {{xml .Check}}{{else}}<b>{{xml .Label}}</b>{{end}}
+{{- with .Children}}<ul>{{range .}}{{template "check" .}}{{end}}</ul>{{end -}}
+</li>{{end}}
+`
@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
+	"html/template"
+	"io"
 	"log"
 	"os"
 	"path"
@@ -15,106 +17,487 @@ import (
 	"github.com/russross/blackfriday"
 )
 
-var htmlOut = os.Getenv("HTML_OUTDIR")
+var (
+	htmlOut       = os.Getenv("HTML_OUTDIR")
+	htmlTemplates = os.Getenv("HTML_TEMPLATES")
+	htmlTitle     = os.Getenv("HTML_TITLE")
+	htmlSite      = os.Getenv("HTML_MODE") == "site"
+	htmlAssets    = os.Getenv("HTML_ASSETS")
+)
 
 func MakeHTML() {
 	src, err := getSource()
 	if err != nil {
 		log.Fatalln(err)
 	}
-	root, err := core.NewRoot(core.Components...)
+	root, err := decodeRoot(src)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	if err := root.Decode(src); err != nil {
+	h, err := newHTMLExporter(root)
+	if err != nil {
 		log.Fatalln(err)
 	}
-	images := map[[4]string]string{}
+	var langs []*core.Category
 	for _, loc := range strings.Split(os.Getenv("HTML_LANGS"), ",") {
-		b := bytes.NewBuffer(nil)
-		for _, lang := range root.Sub {
-			if lang.ID != loc {
-				continue
-			}
-			for _, cat := range lang.Sub {
-				if cat.ID == "forms" || cat.ID == "glossary" {
-					continue
-				}
-				fmt.Fprintf(b, "<hr><h1>%s</h1>", cat.Meta["title"])
-				for _, sub := range cat.Sub {
-					fmt.Fprintf(b, "<hr><h2>%s</h2>", sub.Meta["title"])
-					if cat.ID == "tools" {
-						addSegments(b, lang, cat, sub, sub, images)
-						continue
-					}
-					for _, dif := range sub.Sub {
-						addSegments(b, lang, cat, sub, dif, images)
-					}
-				}
-			}
-			if err := ioutil.WriteFile(filepath.Join(htmlOut, loc+".html"), b.Bytes(), 0666); err != nil {
-				log.Println(loc, err)
+		for i := range root.Sub {
+			if root.Sub[i].ID == loc {
+				langs = append(langs, &root.Sub[i])
 			}
 		}
 	}
+	for _, lang := range langs {
+		if htmlSite {
+			err = h.writeSite(htmlOut, lang, langs)
+		} else {
+			err = h.write(filepath.Join(htmlOut, lang.ID+".html"), lang)
+		}
+		if err != nil {
+			log.Println(lang.ID, err)
+		}
+	}
+	htmlIssues.print("Unsafe HTML")
+	printSavings()
 }
 
-func addSegments(b *bytes.Buffer, lang, cat, sub, dif core.Category, images map[[4]string]string) {
-	fmt.Fprintf(b, "<hr><h3>%s</h3>", dif.Meta["title"])
+// htmlDoc contains the data for the document template.
+type htmlDoc struct {
+	Lang, Dir, Title string
+	Nodes            []docNode
+}
 
-	if lang.ID == projectLang {
-		for _, cmp := range dif.Components {
-			img, ok := cmp.(*core.Picture)
-			if !ok {
-				continue
-			}
-			img.ID = path.Base(img.ID)
-			images[[4]string{cat.ID, sub.ID, dif.ID, img.ID}] = base64.StdEncoding.EncodeToString(img.Data)
+// htmlPage contains the data for the page template of the site mode, Node
+// is nil for the language index.
+type htmlPage struct {
+	htmlDoc
+	Node     *docNode
+	Crumbs   []htmlLink
+	Langs    []htmlLink
+	Children []htmlLink
+}
+
+// PageTitle returns the title of the page.
+func (p htmlPage) PageTitle() string {
+	if p.Node == nil {
+		return p.Title
+	}
+	return p.Node.Title() + " - " + p.Title
+}
+
+// htmlLink is a link to another page, Href is empty for the current one.
+// Lang is set for the links to other languages.
+type htmlLink struct {
+	Title, Href, Lang string
+}
+
+type htmlExporter struct {
+	tmpl    *template.Template
+	site    bool
+	images  imageIndex
+	data    map[*core.Picture][]byte
+	assets  map[string]bool
+	lang    string
+	anchors map[string]bool
+}
+
+// newHTMLExporter parses the default templates, overriding them with the
+// ones in HTML_TEMPLATES, if specified.
+func newHTMLExporter(root *core.Root) (*htmlExporter, error) {
+	h := htmlExporter{
+		site:   htmlSite,
+		images: newImageIndex(root),
+		data:   make(map[*core.Picture][]byte),
+		assets: make(map[string]bool),
+	}
+	t, err := template.New("html").Funcs(template.FuncMap{
+		"heading":  heading,
+		"markdown": h.markdown,
+		"screens":  formScreens,
+	}).Parse(htmlTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if htmlTemplates != "" {
+		if t, err = t.ParseGlob(filepath.Join(htmlTemplates, "*.html")); err != nil {
+			return nil, err
 		}
 	}
+	h.tmpl = t
+	return &h, nil
+}
 
-	for _, cmp := range dif.Components {
-		seg, ok := cmp.(*core.Segment)
-		if !ok {
-			check, ok := cmp.(*core.Checks)
-			if !ok {
-				continue
-			}
-			fmt.Fprintf(b, "<hr><h3>Checklist</h3><ul>")
-			for _, c := range check.List {
-				addCheck(b, c, 0)
-			}
-			fmt.Fprintf(b, "</ul>")
+// write creates the HTML document for the language.
+func (h *htmlExporter) write(name string, lang *core.Category) error {
+	return h.execute(name, "document", h.prepare(lang))
+}
+
+// writeSite creates a page for the language index and one for each node.
+func (h *htmlExporter) writeSite(dir string, lang *core.Category, langs []*core.Category) error {
+	doc := h.prepare(lang)
+	index := htmlPage{htmlDoc: doc, Langs: h.langLinks(nil, langs)}
+	for _, n := range doc.Nodes {
+		index.Children = append(index.Children, htmlLink{Title: n.Title(), Href: relPath(nil, n.Path)})
+	}
+	if err := h.execute(filepath.Join(dir, lang.ID, "index.html"), "page", index); err != nil {
+		return err
+	}
+	for i := range doc.Nodes {
+		if err := h.writeNode(dir, doc, &doc.Nodes[i], nil, langs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *htmlExporter) writeNode(dir string, doc htmlDoc, n *docNode, parents []*docNode, langs []*core.Category) error {
+	page := htmlPage{htmlDoc: doc, Node: n, Langs: h.langLinks(n.Path, langs)}
+	page.Crumbs = append(page.Crumbs, htmlLink{Title: doc.Title, Href: relPath(n.Path, nil)})
+	for _, p := range parents {
+		page.Crumbs = append(page.Crumbs, htmlLink{Title: p.Title(), Href: relPath(n.Path, p.Path)})
+	}
+	page.Crumbs = append(page.Crumbs, htmlLink{Title: n.Title()})
+	for _, s := range n.Sub {
+		page.Children = append(page.Children, htmlLink{Title: s.Title(), Href: relPath(n.Path, s.Path)})
+	}
+	name := filepath.Join(append(append([]string{dir, doc.Lang}, n.Path...), "index.html")...)
+	if err := h.execute(name, "page", page); err != nil {
+		return err
+	}
+	parents = append(parents[:len(parents):len(parents)], n)
+	for i := range n.Sub {
+		if err := h.writeNode(dir, doc, &n.Sub[i], parents, langs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// langLinks returns the links to the same page in the other languages.
+func (h *htmlExporter) langLinks(parts []string, langs []*core.Category) []htmlLink {
+	var links []htmlLink
+	for _, l := range langs {
+		if findCategory(l, parts) == nil {
 			continue
 		}
-		seg.Body = imgFinder.ReplaceAllFunc(seg.Body, func(b []byte) []byte {
-			name := string(b[bytes.Index(b, []byte{'('})+1 : len(b)-1])
-			data := images[[4]string{cat.ID, sub.ID, dif.ID, path.Base(name)}]
-			if data == "" {
-				log.Println("Cannot find", name, "in", cat.ID, sub.ID, dif.ID, data)
-				return b
-			}
-			return []byte("![image](data:image/png;base64, " + data + ")")
-		})
-		fmt.Fprintf(b, "<hr><h3>%s</h3>", seg.Meta["title"])
-		data := blackfriday.Run(seg.Body)
-		b.Write(data)
+		link := htmlLink{Title: langMeta(l.ID).Name, Lang: l.ID, Href: strings.Repeat("../", len(parts)+1) + path.Join(append([]string{l.ID}, parts...)...) + "/index.html"}
+		if l.Meta["title"] != "" {
+			link.Title = l.Meta["title"]
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// relPath returns the link from a page to another, using the directory index.
+func relPath(from, to []string) string {
+	if len(to) == 0 {
+		return strings.Repeat("../", len(from)) + "index.html"
 	}
+	return strings.Repeat("../", len(from)) + path.Join(to...) + "/index.html"
 }
 
-func addCheck(b *bytes.Buffer, c core.Check, deep int) {
-	fmt.Fprintf(b, "<li>")
-	if c.Check != "" {
-		fmt.Fprintf(b, `<input type="checkbox"/> %s`, c.Check)
-	} else {
-		fmt.Fprintf(b, `<b>%s</b>`, c.Label)
+func (h *htmlExporter) execute(name, tmpl string, data interface{}) error {
+	b := bytes.NewBuffer(nil)
+	if err := h.tmpl.ExecuteTemplate(b, tmpl, data); err != nil {
+		return err
 	}
-	if len(c.Children) != 0 {
-		fmt.Fprintf(b, "<ul>")
-		for _, child := range c.Children {
-			addCheck(b, child, deep+1)
+	return writeFile(name, b.Bytes())
+}
+
+// prepare returns the document for the language, collecting its anchors.
+func (h *htmlExporter) prepare(lang *core.Category) htmlDoc {
+	doc := htmlDoc{Lang: lang.ID, Dir: langMeta(lang.ID).Dir(), Title: htmlTitle, Nodes: newDoc(lang)}
+	if doc.Title == "" {
+		doc.Title = "Umbrella"
+	}
+	h.lang = lang.ID
+	h.anchors = make(map[string]bool)
+	for _, n := range doc.Nodes {
+		h.addAnchors(n)
+	}
+	return doc
+}
+
+func (h *htmlExporter) addAnchors(n docNode) {
+	h.anchors[n.Anchor()] = true
+	for _, i := range n.Items() {
+		h.anchors[i.Anchor()] = true
+	}
+	for _, s := range n.Sub {
+		h.addAnchors(s)
+	}
+}
+
+// markdown renders the segment of the item, with its images, localized or
+// from the project language, and replacing umbrella links with anchors. The
+// result is sanitized, reporting any HTML that was removed.
+func (h *htmlExporter) markdown(i docItem) template.HTML {
+	body := imgFinder.ReplaceAllFunc(i.Segment.Body, func(b []byte) []byte {
+		name := string(b[bytes.Index(b, []byte{'('})+1 : len(b)-1])
+		pic := h.images.find(h.lang, i.Path, name)
+		if pic == nil {
+			log.Println("Cannot find", name, "in", i.Path)
+			return b
+		}
+		return []byte("![image](" + h.imageSrc(i.Path, pic) + ")")
+	})
+	body = linkFinder.ReplaceAllFunc(body, func(b []byte) []byte {
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(string(b), linkPrefix), "/"), "/")
+		if !h.anchors[anchorID(parts)] {
+			log.Println("Cannot resolve", string(b), "in", i.Path)
+			return b
+		}
+		return []byte(h.href(i.Path, parts))
+	})
+	clean, removed := sanitizeHTML(string(blackfriday.Run(body, blackfriday.WithRenderer(newHTMLRenderer(blackfriday.CommonHTMLFlags)))))
+	for _, r := range removed {
+		htmlIssues.add(h.lang, path.Join(path.Join(i.Path...), componentName(i.Segment)), "removed %s", r)
+	}
+	return template.HTML(clean)
+}
+
+// imageSrc returns the source of the picture: a data URI, or a relative link
+// to a file in the HTML_ASSETS directory, named after its contents.
+func (h *htmlExporter) imageSrc(from []string, pic *core.Picture) string {
+	data, ok := h.data[pic]
+	if !ok {
+		data = optimizeImage(pic.ID, pic.Data)
+		h.data[pic] = data
+	}
+	kind := imageType(data)
+	if kind == "" {
+		log.Println("Unsupported image", pic.ID)
+		kind = "application/octet-stream"
+	}
+	if htmlAssets == "" {
+		return "data:" + kind + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	sum := sha256.Sum256(data)
+	name := fmt.Sprintf("%x", sum[:8]) + imageTypes[kind]
+	if !h.assets[name] {
+		if err := writeFile(filepath.Join(htmlOut, htmlAssets, name), data); err != nil {
+			log.Println("Cannot write", pic.ID, err)
+		}
+		h.assets[name] = true
+	}
+	var up string
+	if h.site {
+		up = strings.Repeat("../", len(from)+1)
+	}
+	return up + path.Join(filepath.ToSlash(htmlAssets), name)
+}
+
+// href returns the link from the page to the tree path, which is an anchor
+// in the single document or a relative link in site mode.
+func (h *htmlExporter) href(from, parts []string) string {
+	if !h.site {
+		return "#" + anchorID(parts)
+	}
+	if l := len(parts); path.Ext(parts[l-1]) != "" {
+		return relPath(from, parts[:l-1]) + "#" + anchorID(parts)
+	}
+	return relPath(from, parts)
+}
+
+// htmlScreen is a form screen, ready for the template.
+type htmlScreen struct {
+	Title  string
+	Fields []htmlField
+}
+
+// htmlField is a form item, ready for the template.
+type htmlField struct {
+	ID, Type, Label, Hint string
+	Required              bool
+	Lines                 int
+	Options               []formOption
+}
+
+// formScreens returns the screens of the form item, with unique field IDs.
+func formScreens(i docItem) []htmlScreen {
+	var screens []htmlScreen
+	for _, s := range i.Form.Screens {
+		screen := htmlScreen{Title: metaString(s.Meta, "title")}
+		for _, item := range s.Items {
+			screen.Fields = append(screen.Fields, htmlField{
+				ID:       i.Anchor() + "." + item.Name,
+				Type:     item.Type,
+				Label:    metaString(item.Meta, "label"),
+				Hint:     metaString(item.Meta, "hint"),
+				Required: item.Required,
+				Lines:    formLines(item),
+				Options:  formOptions(item),
+			})
 		}
-		fmt.Fprintf(b, "</ul>")
+		screens = append(screens, screen)
 	}
-	fmt.Fprintf(b, "</li>")
+	return screens
 }
+
+// metaString returns the string value of a key, or an empty string.
+func metaString(m core.Map, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// heading returns an HTML heading for the level, from h1 to h6.
+func heading(level int, text string) template.HTML {
+	if level > 6 {
+		level = 6
+	}
+	return template.HTML(fmt.Sprintf("<h%[1]d>%[2]s</h%[1]d>", level, template.HTMLEscapeString(text)))
+}
+
+// htmlRenderer isolates the text of links, that can mix directions.
+type htmlRenderer struct {
+	*blackfriday.HTMLRenderer
+}
+
+func newHTMLRenderer(flags blackfriday.HTMLFlags) htmlRenderer {
+	return htmlRenderer{blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{Flags: flags})}
+}
+
+func (r htmlRenderer) RenderNode(w io.Writer, node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+	if node.Type == blackfriday.Link && !entering {
+		io.WriteString(w, "</bdi>")
+	}
+	status := r.HTMLRenderer.RenderNode(w, node, entering)
+	if node.Type == blackfriday.Link && entering {
+		io.WriteString(w, "<bdi>")
+	}
+	return status
+}
+
+const htmlTemplate = `
+{{- define "document" -}}
+<!DOCTYPE html>
+<html lang="{{.Lang}}" dir="{{.Dir}}">
+<head>{{template "head" .Title}}</head>
+<body>
+{{template "toc" .}}
+<main>
+{{range .Nodes}}{{template "node" .}}{{end}}
+</main>
+</body>
+</html>
+{{end}}
+
+{{- define "page" -}}
+<!DOCTYPE html>
+<html lang="{{.Lang}}" dir="{{.Dir}}">
+<head>{{template "head" .PageTitle}}</head>
+<body>
+<header>
+<nav class="crumbs">{{range $i, $c := .Crumbs}}{{if $i}} &rsaquo; {{end}}{{if .Href}}<a href="{{.Href}}"><bdi>{{.Title}}</bdi></a>{{else}}<bdi>{{.Title}}</bdi>{{end}}{{end}}</nav>
+<nav class="langs">{{range .Langs}}<a href="{{.Href}}" hreflang="{{.Lang}}" lang="{{.Lang}}" dir="auto">{{.Title}}</a> {{end}}</nav>
+</header>
+<main>
+{{with .Node}}{{heading 1 .Title}}
+{{with .Description}}<p class="description">{{.}}</p>{{end}}
+{{template "items" .}}
+{{else}}<h1>{{.Title}}</h1>{{end}}
+{{with .Children}}<ul class="index">{{range .}}<li><a href="{{.Href}}">{{.Title}}</a></li>{{end}}</ul>{{end}}
+</main>
+</body>
+</html>
+{{end}}
+
+{{- define "head"}}
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>{{template "style"}}</style>
+{{end}}
+
+{{- define "style"}}
+body { font-family: sans-serif; line-height: 1.5; color: #222; margin: 0; }
+main { max-width: 50em; margin: 0 auto; padding: 1em; }
+section.depth-1 { border-top: 2px solid #333; margin-top: 2em; }
+article { border-top: 1px solid #ccc; margin-top: 1em; }
+.description { color: #555; font-style: italic; }
+ul.checklist { list-style: none; padding-inline-start: 1em; }
+nav.toc { max-width: 50em; margin: 0 auto; padding: 1em; }
+nav.toc ul { list-style: none; padding-inline-start: 1em; }
+header { background: #eee; padding: .5em 1em; display: flex; justify-content: space-between; }
+img { max-width: 100%; }
+dl.glossary dt { font-weight: bold; margin-top: 1em; }
+form fieldset { margin: 1em 0; }
+.field { margin: .5em 0; }
+.field label, .field .label { display: block; font-weight: bold; }
+.field input[type=text], .field textarea { width: 100%; }
+.field ul.options { list-style: none; padding-inline-start: 0; }
+.field ul.options label { font-weight: normal; }
+.hint { color: #555; }
+ul, ol { padding-inline-start: 2em; padding-inline-end: 0; }
+blockquote { margin-inline: 0; padding-inline-start: 1em; border-inline-start: 3px solid #ccc; }
+{{end}}
+
+{{- define "toc"}}
+<nav class="toc">
+<h1>{{.Title}}</h1>
+<ul>{{range .Nodes}}{{template "toc-node" .}}{{end}}</ul>
+</nav>
+{{end}}
+
+{{- define "toc-node"}}<li><a href="#{{.Anchor}}">{{.Title}}</a>
+{{- if or .Sub (and .Entries (not .IsGlossary))}}<ul>
+{{- range .Sub}}{{template "toc-node" .}}{{end}}
+{{- if not .IsGlossary}}{{range .Entries}}<li><a href="#{{.Anchor}}">{{.Title}}</a></li>{{end}}{{end -}}
+</ul>{{end -}}
+</li>{{end}}
+
+{{- define "node"}}
+<section id="{{.Anchor}}" class="depth-{{.Depth}}">
+{{heading .Depth .Title}}
+{{with .Description}}<p class="description">{{.}}</p>{{end}}
+{{template "items" .}}
+{{range .Sub}}{{template "node" .}}{{end}}
+</section>
+{{end}}
+
+{{- define "items"}}
+{{- if .IsGlossary}}<dl class="glossary">
+{{- range .Items}}{{if .Segment}}
+<dt id="{{.Anchor}}">{{.Title}}</dt>
+<dd>{{markdown .}}</dd>{{end}}{{end}}
+</dl>
+{{- else}}{{range .Items}}{{template "item" .}}{{end}}{{end}}
+{{- end}}
+
+{{- define "item"}}
+{{with .Segment}}<article id="{{$.Anchor}}">
+<h4>{{$.Title}}</h4>
+{{markdown $}}
+</article>{{end}}
+{{with .Checks}}<article id="{{$.Anchor}}">
+<h4>{{$.Title}}</h4>
+<ul class="checklist">{{range .List}}{{template "check" .}}{{end}}</ul>
+</article>{{end}}
+{{with .Form}}<article id="{{$.Anchor}}">
+<h4>{{$.Title}}</h4>
+<form class="form" action="#">
+{{range screens $}}<fieldset>
+{{with .Title}}<legend>{{.}}</legend>{{end}}
+{{range .Fields}}{{template "field" .}}{{end}}
+</fieldset>
+{{end}}</form>
+</article>{{end}}
+{{end}}
+
+{{- define "field"}}<div class="field">
+{{- if eq .Type "label"}}<p>{{.Label}}</p>
+{{- else if .Options}}{{$f := .}}<span class="label">{{.Label}}</span>
+{{- with .Hint}} <small class="hint">{{.}}</small>{{end}}
+<ul class="options">{{range .Options}}<li><label><input type="{{if eq $f.Type "multiple_choice"}}checkbox{{else}}radio{{end}}" name="{{$f.ID}}" value="{{.Value}}"> {{.Label}}</label></li>{{end}}</ul>
+{{- else}}<label for="{{.ID}}">{{.Label}}</label>
+{{- with .Hint}} <small class="hint">{{.}}</small>{{end}}
+{{if gt .Lines 1}}<textarea id="{{.ID}}" name="{{.ID}}" rows="{{.Lines}}"{{if .Required}} required{{end}}></textarea>
+{{- else}}<input id="{{.ID}}" name="{{.ID}}" type="text"{{if .Required}} required{{end}}>{{end}}
+{{- end -}}
+</div>{{end}}
+
+{{- define "check"}}<li>
+{{- if .Check}}<label><input type="checkbox"> {{.Check}}</label>{{else}}<b>{{.Label}}</b>{{end}}
+{{- with .Children}}<ul>{{range .}}{{template "check" .}}{{end}}</ul>{{end -}}
+</li>{{end}}
+`
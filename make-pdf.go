@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/russross/blackfriday"
+)
+
+var (
+	pdfOut      = os.Getenv("PDF_OUTDIR")
+	pdfTitle    = os.Getenv("PDF_TITLE")
+	pdfFont     = os.Getenv("PDF_FONT")
+	pdfFontBold = os.Getenv("PDF_FONT_BOLD")
+)
+
+const (
+	pdfSize = 10.0
+	pdfLine = 5.0
+)
+
+func MakePDF() {
+	src, err := getSource()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root, err := decodeRoot(src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	images := newImageIndex(root)
+	for _, loc := range strings.Split(os.Getenv("PDF_LANGS"), ",") {
+		for i := range root.Sub {
+			lang := &root.Sub[i]
+			if lang.ID != loc {
+				continue
+			}
+			if err := writePDF(filepath.Join(pdfOut, loc+".pdf"), lang, images); err != nil {
+				log.Println(loc, err)
+			}
+		}
+	}
+}
+
+// writePDF renders the language twice, the first time to know the page
+// numbers for the table of contents. Languages not written in Latin script
+// need PDF_FONT, since the standard fonts cannot show them.
+func writePDF(name string, lang *core.Category, images imageIndex) error {
+	if s := langMeta(lang.ID).Script; s != "Latn" && pdfFont == "" {
+		return fmt.Errorf("%s script needs PDF_FONT", s)
+	}
+	nodes := newDoc(lang)
+	w, err := newPDFWriter(lang, images, nodes, nil)
+	if err != nil {
+		return err
+	}
+	w.render()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if w, err = newPDFWriter(lang, images, nodes, w.pages); err != nil {
+		return err
+	}
+	w.render()
+	b := bytes.NewBuffer(nil)
+	if err := w.Output(b); err != nil {
+		return err
+	}
+	return writeFile(name, b.Bytes())
+}
+
+type pdfWriter struct {
+	*gofpdf.Fpdf
+	lang   *core.Category
+	images imageIndex
+	nodes  []docNode
+	family string
+	tr     func(string) string
+	links  map[string]int
+	pages  map[string]int
+	toc    map[string]int
+	bold   int
+	link   string
+	rtl    bool
+	para   strings.Builder
+}
+
+func newPDFWriter(lang *core.Category, images imageIndex, nodes []docNode, toc map[string]int) (*pdfWriter, error) {
+	w := pdfWriter{
+		Fpdf:   gofpdf.New("P", "mm", "A4", ""),
+		lang:   lang,
+		images: images,
+		nodes:  nodes,
+		links:  make(map[string]int),
+		pages:  make(map[string]int),
+		toc:    toc,
+	}
+	if pdfFont != "" {
+		regular, err := ioutil.ReadFile(pdfFont)
+		if err != nil {
+			return nil, err
+		}
+		bold := regular
+		if pdfFontBold != "" {
+			if bold, err = ioutil.ReadFile(pdfFontBold); err != nil {
+				return nil, err
+			}
+		}
+		w.AddUTF8FontFromBytes("text", "", regular)
+		w.AddUTF8FontFromBytes("text", "B", bold)
+		w.family, w.tr = "text", func(s string) string { return s }
+	} else {
+		w.family, w.tr = "Helvetica", w.UnicodeTranslatorFromDescriptor("")
+	}
+	if langMeta(lang.ID).RTL {
+		w.RTL()
+		w.rtl = true
+	}
+	w.SetTitle(w.title(), true)
+	w.SetFooterFunc(func() {
+		if w.PageNo() == 1 {
+			return
+		}
+		w.SetY(-15)
+		w.setFont("", pdfSize-2)
+		w.CellFormat(0, 10, fmt.Sprint(w.PageNo()), "", 0, "C", false, 0, "")
+	})
+	for _, n := range nodes {
+		w.addLinks(n)
+	}
+	return &w, w.Error()
+}
+
+func (w *pdfWriter) title() string {
+	if pdfTitle != "" {
+		return pdfTitle
+	}
+	return "Umbrella"
+}
+
+func (w *pdfWriter) addLinks(n docNode) {
+	w.links[n.Anchor()] = w.AddLink()
+	for _, i := range n.Items() {
+		w.links[i.Anchor()] = w.AddLink()
+	}
+	for _, s := range n.Sub {
+		w.addLinks(s)
+	}
+}
+
+// setFont sets the text font, bold is the only style available for custom fonts.
+func (w *pdfWriter) setFont(style string, size float64) {
+	if w.bold > 0 {
+		style = "B"
+	}
+	w.SetFont(w.family, style, size)
+}
+
+// anchor marks the current position as destination of the anchor.
+func (w *pdfWriter) anchor(id string) {
+	w.SetLink(w.links[id], w.GetY(), w.PageNo())
+	w.pages[id] = w.PageNo()
+}
+
+func (w *pdfWriter) render() {
+	w.AddPage()
+	w.SetY(100)
+	w.setFont("B", 32)
+	w.MultiCell(0, 14, w.tr(w.title()), "", "C", false)
+	w.setFont("", 18)
+	title := w.lang.Meta["title"]
+	if title == "" {
+		title = w.lang.ID
+	}
+	w.MultiCell(0, 10, w.tr(title), "", "C", false)
+
+	w.AddPage()
+	w.setFont("B", 18)
+	w.block(0, 10, "Contents")
+	w.Ln(pdfLine)
+	for _, n := range w.nodes {
+		w.tocEntry(n)
+	}
+	for _, n := range w.nodes {
+		w.AddPage()
+		w.node(n)
+	}
+}
+
+func (w *pdfWriter) tocEntry(n docNode) {
+	w.entry(n.Depth(), n.Title(), n.Anchor())
+	for _, i := range n.Entries() {
+		w.entry(n.Depth()+1, i.Title(), i.Anchor())
+	}
+	for _, s := range n.Sub {
+		w.tocEntry(s)
+	}
+}
+
+func (w *pdfWriter) entry(depth int, title, anchor string) {
+	var page string
+	if p, ok := w.toc[anchor]; ok {
+		page = fmt.Sprint(p)
+	}
+	style := ""
+	if depth == 1 {
+		style = "B"
+	}
+	w.setFont(style, pdfSize)
+	indent := float64(depth-1) * 5
+	left, _, right, _ := w.GetMargins()
+	width, _ := w.GetPageSize()
+	if w.rtl {
+		w.SetX(left)
+		w.CellFormat(15, pdfLine+1, page, "", 0, "L", false, w.links[anchor], "")
+		w.CellFormat(width-left-right-indent-15, pdfLine+1, w.tr(title), "", 1, "R", false, w.links[anchor], "")
+		return
+	}
+	w.SetX(left + indent)
+	w.CellFormat(width-left-right-indent-15, pdfLine+1, w.tr(title), "", 0, "", false, w.links[anchor], "")
+	w.CellFormat(15, pdfLine+1, page, "", 1, "R", false, w.links[anchor], "")
+}
+
+// block writes a paragraph, indented from the start of the line, that is
+// the right side for right to left languages.
+func (w *pdfWriter) block(indent, h float64, s string) {
+	left, top, right, _ := w.GetMargins()
+	if w.rtl {
+		w.SetRightMargin(right + indent)
+		w.SetX(left)
+		w.MultiCell(0, h, w.tr(s), "", "R", false)
+	} else {
+		w.SetLeftMargin(left + indent)
+		w.SetX(left + indent)
+		w.MultiCell(0, h, w.tr(s), "", "", false)
+	}
+	w.SetMargins(left, top, right)
+}
+
+// flush writes the text collected for a right to left paragraph, aligned
+// to the right.
+func (w *pdfWriter) flush() {
+	if w.para.Len() == 0 {
+		return
+	}
+	left, _, right, _ := w.GetMargins()
+	width, _ := w.GetPageSize()
+	for _, l := range w.SplitText(w.para.String(), width-left-right) {
+		w.SetX(left)
+		w.CellFormat(width-left-right, pdfLine, l, "", 1, "R", false, 0, "")
+	}
+	w.para.Reset()
+}
+
+func (w *pdfWriter) node(n docNode) {
+	size := 20 - 2*float64(n.Depth())
+	w.Ln(pdfLine)
+	w.anchor(n.Anchor())
+	w.Bookmark(w.tr(n.Title()), n.Depth()-1, -1)
+	w.setFont("B", size)
+	w.block(0, size/2, n.Title())
+	if d := n.Description(); d != "" {
+		w.setFont("", pdfSize)
+		w.block(0, pdfLine, d)
+	}
+	for _, i := range n.Items() {
+		w.Ln(pdfLine)
+		w.anchor(i.Anchor())
+		w.setFont("B", 12)
+		w.block(0, 6, i.Title())
+		w.setFont("", pdfSize)
+		switch {
+		case i.Segment != nil:
+			w.Bookmark(w.tr(i.Title()), n.Depth(), -1)
+			w.markdown(n, i.Segment.Body)
+		case i.Checks != nil:
+			w.checks(i.Checks.List, 0)
+		case i.Form != nil:
+			w.Bookmark(w.tr(i.Title()), n.Depth(), -1)
+			w.form(i.Form)
+		}
+	}
+	for _, s := range n.Sub {
+		w.node(s)
+	}
+}
+
+// markdown renders the segment body, resolving the images of the node.
+// Right to left paragraphs are written as a whole, without inline styles and
+// links, because text is reversed line by line.
+func (w *pdfWriter) markdown(n docNode, body []byte) {
+	var (
+		left, _, right, _ = w.GetMargins()
+		numbers           []int
+	)
+	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	md.Parse(body).Walk(func(node *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		switch node.Type {
+		case blackfriday.Heading:
+			if entering {
+				w.Ln(2)
+				w.bold++
+				w.setFont("", 14-float64(node.Level))
+			} else {
+				if w.rtl {
+					w.flush()
+					w.Ln(1)
+				} else {
+					w.Ln(pdfLine + 1)
+				}
+				w.bold--
+				w.setFont("", pdfSize)
+			}
+		case blackfriday.Paragraph:
+			if !entering {
+				if w.rtl {
+					w.flush()
+				} else {
+					w.Ln(pdfLine)
+				}
+				if p := node.Parent; p.Type != blackfriday.Item || !p.Parent.Tight {
+					w.Ln(2)
+				}
+			}
+		case blackfriday.List:
+			l, _, r, _ := w.GetMargins()
+			if entering {
+				numbers = append(numbers, 0)
+				if w.rtl {
+					w.SetRightMargin(r + 5)
+				} else {
+					w.SetLeftMargin(w.GetX() + 5)
+				}
+			} else {
+				numbers = numbers[:len(numbers)-1]
+				if w.rtl {
+					w.SetRightMargin(r - 5)
+				} else {
+					w.SetLeftMargin(l - 5)
+				}
+				if len(numbers) == 0 {
+					w.SetLeftMargin(left)
+					w.SetRightMargin(right)
+					w.Ln(2)
+				}
+			}
+		case blackfriday.Item:
+			if entering {
+				bullet := "-"
+				if node.ListFlags&blackfriday.ListTypeOrdered != 0 {
+					numbers[len(numbers)-1]++
+					bullet = fmt.Sprintf("%d.", numbers[len(numbers)-1])
+				}
+				if w.rtl {
+					w.para.WriteString(bullet + " ")
+					break
+				}
+				l, _, _, _ := w.GetMargins()
+				w.SetX(l - 5)
+				w.CellFormat(5, pdfLine, bullet, "", 0, "", false, 0, "")
+			}
+		case blackfriday.Strong:
+			if entering {
+				w.bold++
+			} else {
+				w.bold--
+			}
+			w.setFont("", pdfSize)
+		case blackfriday.Link:
+			w.link = ""
+			if entering {
+				w.link = string(node.Destination)
+			}
+		case blackfriday.Image:
+			if entering {
+				w.flush()
+				w.image(n, string(node.Destination))
+			}
+			return blackfriday.SkipChildren
+		case blackfriday.Text:
+			w.text(string(node.Literal))
+		case blackfriday.Code:
+			if w.rtl {
+				w.para.WriteString(string(node.Literal))
+				break
+			}
+			w.SetFont("Courier", "", pdfSize)
+			w.Write(pdfLine, w.tr(string(node.Literal)))
+			w.setFont("", pdfSize)
+		case blackfriday.CodeBlock:
+			w.flush()
+			if w.rtl {
+				w.LTR()
+			}
+			w.SetFont("Courier", "", pdfSize-1)
+			w.MultiCell(0, pdfLine-1, w.tr(string(node.Literal)), "", "", false)
+			w.setFont("", pdfSize)
+			if w.rtl {
+				w.RTL()
+			}
+		case blackfriday.Softbreak:
+			if w.rtl {
+				w.para.WriteString(" ")
+				break
+			}
+			w.Write(pdfLine, " ")
+		case blackfriday.Hardbreak:
+			if w.rtl {
+				w.flush()
+				break
+			}
+			w.Ln(pdfLine)
+		}
+		return blackfriday.GoToNext
+	})
+	w.flush()
+	w.SetLeftMargin(left)
+	w.SetRightMargin(right)
+}
+
+// text writes a piece of text, inside the current link if any.
+func (w *pdfWriter) text(s string) {
+	switch {
+	case w.rtl:
+		w.para.WriteString(s)
+	case w.link == "":
+		w.Write(pdfLine, w.tr(s))
+	case strings.HasPrefix(w.link, linkPrefix):
+		parts := strings.Split(strings.Trim(strings.TrimPrefix(w.link, linkPrefix), "/"), "/")
+		if id, ok := w.links[anchorID(parts)]; ok {
+			w.WriteLinkID(pdfLine, w.tr(s), id)
+			return
+		}
+		w.Write(pdfLine, w.tr(s))
+	default:
+		w.WriteLinkString(pdfLine, w.tr(s), w.link)
+	}
+}
+
+// image draws a picture of the node, or of the project language, fitting it
+// in the page width.
+func (w *pdfWriter) image(n docNode, name string) {
+	pic := w.images.find(w.lang.ID, n.Path, name)
+	if pic == nil {
+		log.Println("Cannot find", name, "in", n.Path)
+		return
+	}
+	var kind string
+	switch imageType(pic.Data) {
+	case "image/png":
+		kind = "PNG"
+	case "image/jpeg":
+		kind = "JPG"
+	case "image/gif":
+		kind = "GIF"
+	default:
+		log.Println("Unsupported image", name, "in", n.Path)
+		return
+	}
+	id := path.Join(path.Join(n.Path...), path.Base(pic.ID))
+	opts := gofpdf.ImageOptions{ImageType: kind, ReadDpi: true}
+	info := w.GetImageInfo(id)
+	if info == nil {
+		info = w.RegisterImageOptionsReader(id, opts, bytes.NewReader(pic.Data))
+	}
+	if info == nil || w.Err() {
+		log.Println("Invalid image", name, "in", n.Path, w.Error())
+		w.ClearError()
+		return
+	}
+	left, _, right, _ := w.GetMargins()
+	width, _ := w.GetPageSize()
+	iw, ih := info.Extent()
+	if max := width - left - right; iw > max {
+		iw, ih = max, ih*max/iw
+	}
+	w.Ln(pdfLine)
+	w.ImageOptions(id, left, -1, iw, ih, true, opts, 0, "")
+}
+
+// checks draws the checklist with empty checkboxes.
+func (w *pdfWriter) checks(list []core.Check, depth int) {
+	for _, c := range list {
+		indent := float64(depth) * 5
+		if c.Check != "" {
+			w.setFont("", pdfSize)
+			w.checkbox(indent, false)
+			w.block(indent+5, pdfLine, c.Check)
+		} else {
+			w.setFont("B", pdfSize)
+			w.block(indent, pdfLine, c.Label)
+		}
+		w.checks(c.Children, depth+1)
+	}
+	w.setFont("", pdfSize)
+}
+
+// checkbox draws a box, or a circle for single choices, indented from the
+// start of the line. The text goes after it, with an indent of 5 more.
+func (w *pdfWriter) checkbox(indent float64, round bool) {
+	width, bottom := w.GetPageSize()
+	left, _, right, margin := w.GetMargins()
+	if w.GetY()+pdfLine > bottom-margin {
+		w.AddPage()
+	}
+	x, y := left+indent, w.GetY()
+	if w.rtl {
+		x = width - right - indent - 3.5
+	}
+	if round {
+		w.Circle(x+1.75, y+pdfLine/2, 1.75, "D")
+	} else {
+		w.Rect(x, y+(pdfLine-3.5)/2, 3.5, 3.5, "D")
+	}
+}
+
+// form draws the form as blank fields.
+func (w *pdfWriter) form(f *core.Form) {
+	left, _, right, _ := w.GetMargins()
+	width, _ := w.GetPageSize()
+	for _, s := range f.Screens {
+		if t, _ := s.Meta["title"].(string); t != "" {
+			w.Ln(2)
+			w.setFont("B", pdfSize+1)
+			w.block(0, pdfLine+1, t)
+		}
+		for _, i := range s.Items {
+			label, _ := i.Meta["label"].(string)
+			w.setFont("B", pdfSize)
+			w.block(0, pdfLine, label)
+			if hint, _ := i.Meta["hint"].(string); hint != "" {
+				w.setFont("", pdfSize-2)
+				w.block(0, pdfLine-1, hint)
+			}
+			w.setFont("", pdfSize)
+			if options := formOptions(i); len(options) != 0 {
+				for _, o := range options {
+					w.checkbox(0, i.Type == "single_choice")
+					w.block(5, pdfLine, o.Label)
+				}
+				continue
+			}
+			lines := formLines(i)
+			for l := 0; l < lines; l++ {
+				w.Ln(pdfLine + 2)
+				w.Line(left, w.GetY(), width-right, w.GetY())
+			}
+			w.Ln(2)
+		}
+	}
+}
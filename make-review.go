@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/go-tent/tent/core"
+	"github.com/russross/blackfriday"
+)
+
+var reviewOut = os.Getenv("REVIEW_OUTDIR")
+
+// MakeReview creates a page for each language in REVIEW_LANGS, with the
+// project language side by side.
+func MakeReview() {
+	src, err := getSource()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root, err := decodeRoot(src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	source := findCategory(root.Category, []string{projectLang})
+	if source == nil {
+		log.Fatalln("project language not found:", projectLang)
+	}
+	t, err := template.New("review").Funcs(template.FuncMap{"heading": heading}).Parse(reviewTemplate)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, loc := range strings.Split(os.Getenv("REVIEW_LANGS"), ",") {
+		target := findCategory(root.Category, []string{loc})
+		if target == nil || loc == projectLang {
+			continue
+		}
+		r := newReview(source, target)
+		b := bytes.NewBuffer(nil)
+		if err := t.Execute(b, r); err != nil {
+			log.Println(loc, err)
+			continue
+		}
+		if err := writeFile(filepath.Join(reviewOut, loc+".html"), b.Bytes()); err != nil {
+			log.Println(loc, err)
+			continue
+		}
+		log.Printf("[%s] %d strings: %d untranslated, %d missing, %d unexpected", loc,
+			r.Count[""]+r.Count["same"]+r.Count["missing"], r.Count["same"], r.Count["missing"], r.Count["extra"])
+	}
+}
+
+// reviewLang is a column of the review.
+type reviewLang struct {
+	ID, Name, Dir string
+}
+
+// reviewRow is a string in both languages, Status is "same" when it's not
+// translated, "missing" when there is no translation, "extra" when there's
+// no source.
+type reviewRow struct {
+	Label          string
+	Source, Target template.HTML
+	Status         string
+}
+
+// reviewSection contains the rows of a category.
+type reviewSection struct {
+	Anchor, Title string
+	Depth         int
+	Rows          []reviewRow
+}
+
+type review struct {
+	Source, Target reviewLang
+	Sections       []reviewSection
+	Count          map[string]int
+}
+
+func newReview(source, target *core.Category) *review {
+	r := review{
+		Source: reviewLang{source.ID, langMeta(source.ID).Name, langMeta(source.ID).Dir()},
+		Target: reviewLang{target.ID, langMeta(target.ID).Name, langMeta(target.ID).Dir()},
+		Count:  make(map[string]int),
+	}
+	for _, n := range newDoc(source) {
+		r.addNode(n, target)
+	}
+	return &r
+}
+
+func (r *review) addNode(n docNode, target *core.Category) {
+	s := reviewSection{Anchor: n.Anchor(), Title: n.Title(), Depth: n.Depth()}
+	cat := findCategory(target, n.Path)
+	if cat == nil {
+		cat = &core.Category{}
+		s.Rows = append(s.Rows, r.count(reviewRow{Label: "category", Source: template.HTML(template.HTMLEscapeString(path.Join(n.Path...))), Status: "missing"}))
+	}
+	s.Rows = append(s.Rows, r.text("title", n.Title(), cat.Meta["title"]))
+	if n.Description() != "" || cat.Meta["description"] != "" {
+		s.Rows = append(s.Rows, r.text("description", n.Description(), cat.Meta["description"]))
+	}
+	var found = make(map[string]bool)
+	for _, i := range n.Items() {
+		name := componentName(i.Component())
+		found[name] = true
+		dst := findComponent(cat, name)
+		if dst == nil {
+			s.Rows = append(s.Rows, r.count(reviewRow{Label: name, Source: template.HTML(template.HTMLEscapeString(i.Title())), Status: "missing"}))
+			continue
+		}
+		switch {
+		case i.Segment != nil:
+			s.Rows = append(s.Rows, r.segment(name, i.Segment, dst.(*core.Segment))...)
+		case i.Checks != nil:
+			s.Rows = append(s.Rows, r.checks(name, i.Checks, dst.(*core.Checks))...)
+		case i.Form != nil:
+			s.Rows = append(s.Rows, r.form(name, i.Form, dst.(*core.Form))...)
+		}
+	}
+	for _, cmp := range cat.Components {
+		if _, ok := cmp.(*core.Picture); ok || found[componentName(cmp)] {
+			continue
+		}
+		s.Rows = append(s.Rows, r.count(reviewRow{Label: componentName(cmp), Status: "extra"}))
+	}
+	for _, sub := range cat.Sub {
+		if findCategory(n.Cat, []string{sub.ID}) == nil {
+			s.Rows = append(s.Rows, r.count(reviewRow{Label: "category", Target: template.HTML(template.HTMLEscapeString(sub.ID)), Status: "extra"}))
+		}
+	}
+	r.Sections = append(r.Sections, s)
+	for _, sub := range n.Sub {
+		r.addNode(sub, target)
+	}
+}
+
+// count adds the row status to the totals.
+func (r *review) count(row reviewRow) reviewRow {
+	r.Count[row.Status]++
+	return row
+}
+
+// text returns the row for a plain string.
+func (r *review) text(label, src, dst string) reviewRow {
+	return r.count(reviewRow{
+		Label:  label,
+		Source: template.HTML(template.HTMLEscapeString(src)),
+		Target: template.HTML(template.HTMLEscapeString(dst)),
+		Status: textStatus(src, dst),
+	})
+}
+
+func (r *review) segment(name string, src, dst *core.Segment) []reviewRow {
+	body := r.count(reviewRow{
+		Label:  name,
+		Source: reviewMarkdown(src.Body),
+		Target: reviewMarkdown(dst.Body),
+		Status: textStatus(string(bytes.TrimSpace(src.Body)), string(bytes.TrimSpace(dst.Body))),
+	})
+	return []reviewRow{r.text(name+" title", src.Meta["title"], dst.Meta["title"]), body}
+}
+
+func (r *review) checks(name string, src, dst *core.Checks) []reviewRow {
+	var rows []reviewRow
+	if src.Meta["title"] != "" {
+		rows = append(rows, r.text(name+" title", src.Meta["title"], dst.Meta["title"]))
+	}
+	exp, got := flattenChecks("", src.List), flattenChecks("", dst.List)
+	for _, c := range exp {
+		rows = append(rows, r.text(name+" "+c[0], c[1], lookupCheck(got, c[0])))
+	}
+	for _, c := range got {
+		if lookupCheck(exp, c[0]) == "" {
+			rows = append(rows, r.count(reviewRow{Label: name + " " + c[0], Target: template.HTML(template.HTMLEscapeString(c[1])), Status: "extra"}))
+		}
+	}
+	return rows
+}
+
+// flattenChecks returns the key and the text of all the checklist items.
+func flattenChecks(prefix string, list []core.Check) [][2]string {
+	var items [][2]string
+	for i, c := range list {
+		key := fmt.Sprintf("%s%d", prefix, i+1)
+		text := c.Check
+		if text == "" {
+			text = c.Label
+		}
+		items = append(items, [2]string{key, text})
+		items = append(items, flattenChecks(key+".", c.Children)...)
+	}
+	return items
+}
+
+func lookupCheck(items [][2]string, key string) string {
+	for _, c := range items {
+		if c[0] == key {
+			return c[1]
+		}
+	}
+	return ""
+}
+
+func (r *review) form(name string, src, dst *core.Form) []reviewRow {
+	rows := []reviewRow{r.text(name+" title", src.Meta["title"], dst.Meta["title"])}
+	for i, s := range src.Screens {
+		var d core.FormScreen
+		if i < len(dst.Screens) {
+			d = dst.Screens[i]
+		}
+		key := fmt.Sprintf("%s screen %d", name, i+1)
+		rows = append(rows, r.text(key+" title", metaString(s.Meta, "title"), metaString(d.Meta, "title")))
+		for _, item := range s.Items {
+			var t core.FormItem
+			for _, v := range d.Items {
+				if v.Name == item.Name {
+					t = v
+				}
+			}
+			key := key + " " + item.Name
+			for _, k := range []string{"label", "hint"} {
+				if v := metaString(item.Meta, k); v != "" {
+					rows = append(rows, r.text(key+" "+k, v, metaString(t.Meta, k)))
+				}
+			}
+			exp, got := formOptions(item), formOptions(t)
+			for j, o := range exp {
+				var label string
+				if j < len(got) {
+					label = got[j].Label
+				}
+				rows = append(rows, r.text(fmt.Sprintf("%s option %d", key, j+1), o.Label, label))
+			}
+		}
+	}
+	return rows
+}
+
+// findComponent returns the component of the category with the file name.
+func findComponent(cat *core.Category, name string) core.Component {
+	for _, cmp := range cat.Components {
+		if componentName(cmp) == name {
+			return cmp
+		}
+	}
+	return nil
+}
+
+// textStatus returns the review status of a translated string.
+func textStatus(src, dst string) string {
+	switch {
+	case dst == "" && src != "":
+		return "missing"
+	case dst == src && strings.IndexFunc(src, unicode.IsLetter) != -1:
+		return "same"
+	}
+	return ""
+}
+
+// reviewMarkdown renders a segment body, showing images by name.
+func reviewMarkdown(body []byte) template.HTML {
+	body = imgFinder.ReplaceAll(body, []byte("`$1`"))
+	clean, _ := sanitizeHTML(string(blackfriday.Run(body, blackfriday.WithRenderer(newHTMLRenderer(blackfriday.CommonHTMLFlags)))))
+	return template.HTML(clean)
+}
+
+const reviewTemplate = `<!DOCTYPE html>
+<html lang="{{.Target.ID}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Source.Name}} / {{.Target.Name}}</title>
+<style>
+body { font-family: sans-serif; line-height: 1.4; color: #222; margin: 1em; }
+table { border-collapse: collapse; width: 100%; table-layout: fixed; }
+th, td { border: 1px solid #ccc; padding: .4em; vertical-align: top; overflow-wrap: break-word; }
+th.label, td.label { width: 12em; color: #555; font-size: small; }
+tr.same td { background: #fff4cc; }
+tr.missing td { background: #fdd; }
+tr.extra td { background: #dde8ff; }
+.summary span { margin-inline-end: 1em; padding: 0 .3em; }
+.summary .same { background: #fff4cc; }
+.summary .missing { background: #fdd; }
+.summary .extra { background: #dde8ff; }
+</style>
+</head>
+<body>
+<p class="summary">
+<span class="same">untranslated: {{index .Count "same"}}</span>
+<span class="missing">missing: {{index .Count "missing"}}</span>
+<span class="extra">unexpected: {{index .Count "extra"}}</span>
+</p>
+<table>
+<thead><tr><th class="label"></th><th lang="{{.Source.ID}}" dir="{{.Source.Dir}}">{{.Source.Name}}</th><th lang="{{.Target.ID}}" dir="{{.Target.Dir}}">{{.Target.Name}}</th></tr></thead>
+{{- range .Sections}}
+<tbody id="{{.Anchor}}">
+<tr><th colspan="3">{{heading .Depth .Title}}</th></tr>
+{{- range .Rows}}
+<tr{{with .Status}} class="{{.}}"{{end}}><td class="label">{{.Label}}</td><td lang="{{$.Source.ID}}" dir="{{$.Source.Dir}}">{{.Source}}</td><td lang="{{$.Target.ID}}" dir="{{$.Target.Dir}}">{{.Target}}</td></tr>
+{{- end}}
+</tbody>
+{{- end}}
+</table>
+</body>
+</html>
+`
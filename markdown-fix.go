@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mdFix repairs a markdown pattern that translators tend to break, it
+// receives the source and the translation and returns the fixed translation
+// with the number of changes.
+type mdFix struct {
+	Name    string
+	Default bool
+	Fix     func(src, dst string) (string, int)
+}
+
+var mdFixes = []mdFix{
+	{"fullwidth-brackets", true, fixFullWidth},
+	{"link-space", true, regexpFix(`\][ \t\x{a0}\x{3000}]+\(`, "](")},
+	{"image-space", true, regexpFix(`(^|[\s(])![ \t\x{a0}\x{3000}]+\[`, "$1![")},
+	{"link-target", true, fixLinkTarget},
+	{"image-path", true, fixImagePaths},
+	{"emphasis", true, fixEmphasis},
+	{"heading-space", true, fixHeadingSpace},
+	{"adjacent-links", false, regexpFix(`\)[ \t]+\[`, ")[")},
+}
+
+var (
+	fullWidthLink = regexp.MustCompile(`([!！]?)[\[［]([^\]］\n]*)[\]］][ \t]*[(（]([^)）\n]*)[)）]`)
+	linkTarget    = regexp.MustCompile(`\]\(([^)\n]*)\)`)
+	targetSpaces  = regexp.MustCompile(`\s*([/:.])\s*`)
+	targetTitle   = regexp.MustCompile(`\s+("[^"]*"|'[^']*')\s*$`)
+	headingLine   = regexp.MustCompile(`(?m)^(#{1,6})(?:[ \t]|$)`)
+	brokenHeading = regexp.MustCompile(`(?m)^(#{1,6})([^#\s])`)
+	boldText      = regexp.MustCompile(`\*\*[^\s*](?:[^*\n]*[^\s*])?\*\*`)
+)
+
+// mdPipeline is an ordered list of fixes.
+type mdPipeline []mdFix
+
+// newPipeline returns the fixes with the given names, in their default order.
+// An empty list returns the default fixes.
+func newPipeline(names ...string) (mdPipeline, error) {
+	var (
+		p      mdPipeline
+		chosen = make(map[string]bool, len(names))
+	)
+	for _, n := range names {
+		if n = strings.TrimSpace(n); n != "" {
+			chosen[n] = true
+		}
+	}
+	for _, f := range mdFixes {
+		if len(chosen) == 0 && f.Default || chosen[f.Name] {
+			p = append(p, f)
+			delete(chosen, f.Name)
+		}
+	}
+	for n := range chosen {
+		return nil, fmt.Errorf("unknown markdown fix %q", n)
+	}
+	return p, nil
+}
+
+// apply runs all fixes on dst, returning the result and the changes by fix.
+func (p mdPipeline) apply(src, dst string) (string, map[string]int) {
+	var changes = make(map[string]int)
+	for _, f := range p {
+		var n int
+		if dst, n = f.Fix(src, dst); n != 0 {
+			changes[f.Name] += n
+		}
+	}
+	return dst, changes
+}
+
+// regexpFix returns a fix that replaces the expression.
+func regexpFix(expr, repl string) func(_, s string) (string, int) {
+	re := regexp.MustCompile(expr)
+	return func(_, s string) (string, int) {
+		var n int
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			v := re.ReplaceAllString(m, repl)
+			if v != m {
+				n++
+			}
+			return v
+		})
+		return s, n
+	}
+}
+
+// fixFullWidth replaces full-width brackets and parenthesis used in links and images.
+func fixFullWidth(_, s string) (string, int) {
+	var n int
+	s = fullWidthLink.ReplaceAllStringFunc(s, func(m string) string {
+		p := fullWidthLink.FindStringSubmatch(m)
+		v := "[" + p[2] + "](" + p[3] + ")"
+		if p[1] != "" {
+			v = "!" + v
+		}
+		if v == m || !strings.ContainsAny(m, "！［］（）") {
+			return m
+		}
+		n++
+		return v
+	})
+	return s, n
+}
+
+// fixLinkTarget removes spaces and direction markers from link targets,
+// restoring umbrella prefixes. The title of the link is left untouched.
+func fixLinkTarget(_, s string) (string, int) {
+	var n int
+	s = linkTarget.ReplaceAllStringFunc(s, func(m string) string {
+		t := m[2 : len(m)-1]
+		v := strings.Map(func(r rune) rune {
+			if isBidi(r) {
+				return -1
+			}
+			return r
+		}, t)
+		v = prefixFixer.ReplaceAllString(strings.TrimSpace(v), linkPrefix)
+		var title string
+		if i := targetTitle.FindStringIndex(v); i != nil {
+			v, title = v[:i[0]], v[i[0]:]
+		}
+		if strings.HasPrefix(v, linkPrefix) {
+			v = strings.Join(strings.Fields(v), "")
+		} else {
+			v = targetSpaces.ReplaceAllString(v, "$1")
+		}
+		if v += title; v == t {
+			return m
+		}
+		n++
+		return "](" + v + ")"
+	})
+	return s, n
+}
+
+// fixImagePaths restores the source image paths, if the translation has the
+// same number of images.
+func fixImagePaths(src, s string) (string, int) {
+	var (
+		paths []string
+		known = make(map[string]bool)
+	)
+	for _, m := range imgFinder.FindAllStringSubmatch(src, -1) {
+		paths = append(paths, m[1])
+		known[m[1]] = true
+	}
+	if len(imgFinder.FindAllString(s, -1)) != len(paths) {
+		return s, 0
+	}
+	var i, n int
+	s = imgFinder.ReplaceAllStringFunc(s, func(m string) string {
+		defer func() { i++ }()
+		target := imgFinder.FindStringSubmatch(m)[1]
+		if known[target] {
+			return m
+		}
+		n++
+		return m[:strings.LastIndex(m, "(")] + "(" + paths[i] + ")"
+	})
+	return s, n
+}
+
+// fixEmphasis removes the spaces inside bold markers, line by line, only if
+// the source has more bold texts than the translation, so that other uses of
+// the markers are left untouched.
+func fixEmphasis(src, s string) (string, int) {
+	const mark = "**"
+	var (
+		n       int
+		missing = len(boldText.FindAllString(src, -1)) - len(boldText.FindAllString(s, -1))
+	)
+	lines := strings.Split(s, "\n")
+	for i, l := range lines {
+		parts := strings.Split(l, mark)
+		if len(parts) < 3 || len(parts)%2 == 0 {
+			continue
+		}
+		for j := 1; j < len(parts) && missing > 0; j += 2 {
+			if v := strings.TrimSpace(parts[j]); v != parts[j] && v != "" {
+				parts[j] = v
+				missing--
+				n++
+			}
+		}
+		lines[i] = strings.Join(parts, mark)
+	}
+	return strings.Join(lines, "\n"), n
+}
+
+// fixHeadingSpace adds the space after the heading marker, only for the
+// levels that have fewer headings than the source.
+func fixHeadingSpace(src, s string) (string, int) {
+	var missing = make(map[string]int)
+	for _, m := range headingLine.FindAllStringSubmatch(src, -1) {
+		missing[m[1]]++
+	}
+	for _, m := range headingLine.FindAllStringSubmatch(s, -1) {
+		missing[m[1]]--
+	}
+	var n int
+	s = brokenHeading.ReplaceAllStringFunc(s, func(m string) string {
+		p := brokenHeading.FindStringSubmatch(m)
+		if missing[p[1]] <= 0 {
+			return m
+		}
+		missing[p[1]]--
+		n++
+		return p[1] + " " + p[2]
+	})
+	return s, n
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestMarkdownFixes(t *testing.T) {
+	tests := []struct {
+		fix, src, dst, exp string
+		n                  int
+	}{
+		{"fullwidth-brackets", "", "见［链接］（umbrella://a/b）", "见[链接](umbrella://a/b)", 1},
+		{"fullwidth-brackets", "", "！［图］（x.png）", "![图](x.png)", 1},
+		{"fullwidth-brackets", "", "[ok](x)", "[ok](x)", 0},
+		{"link-space", "", "[a] (b) [c] (d)", "[a](b) [c](d)", 2},
+		{"link-space", "", "[a](b)", "[a](b)", 0},
+		{"image-space", "", "! [a](b.png)", "![a](b.png)", 1},
+		{"image-space", "", "See:\n! [a](b.png)", "See:\n![a](b.png)", 1},
+		{"image-space", "", "Hi! [a](b)", "Hi! [a](b)", 0},
+		{"link-target", "", "[a](umbrella : // x / y.md)", "[a](umbrella://x/y.md)", 1},
+		{"link-target", "", "[a](http: //x. com)", "[a](http://x.com)", 1},
+		{"link-target", "", "[a](‏umbrella://x)", "[a](umbrella://x)", 1},
+		{"link-target", "", `[a](http://x "Read more. Now")`, `[a](http://x "Read more. Now")`, 0},
+		{"link-target", "", `[a](http: //x . com 'Read: more')`, `[a](http://x.com 'Read: more')`, 1},
+		{"image-path", "![a](x.png) ![b](y.png)", "![a](x.png) ![b](z.png)", "![a](x.png) ![b](y.png)", 1},
+		{"image-path", "![a](x.png)", "![a](z.png) ![b](y.png)", "![a](z.png) ![b](y.png)", 0},
+		{"emphasis", "a **b** c **d**", "a ** b ** c **d**", "a **b** c **d**", 1},
+		{"emphasis", "**a** **b**", "** a** ** b **", "**a** **b**", 2},
+		{"emphasis", "**a**", "a ** b", "a ** b", 0},
+		{"emphasis", "3 ** 2 = 9 and 2 ** 3", "3 ** 2 = 9 and 2 ** 3", "3 ** 2 = 9 and 2 ** 3", 0},
+		{"emphasis", "**x** 3 ** 2 = 9 and 2 ** 3", "**x** 3 ** 2 = 9 and 2 ** 3", "**x** 3 ** 2 = 9 and 2 ** 3", 0},
+		{"heading-space", "# Title\n## Sub", "#Titolo\n##Sotto", "# Titolo\n## Sotto", 2},
+		{"heading-space", "# Title", "# Titolo\n#hashtag", "# Titolo\n#hashtag", 0},
+		{"heading-space", "Text", "#hashtag", "#hashtag", 0},
+		{"adjacent-links", "", "[a](b) [c](d)", "[a](b)[c](d)", 1},
+	}
+	var tested = make(map[string]bool)
+	for _, tc := range tests {
+		p, err := newPipeline(tc.fix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tested[tc.fix] = true
+		got, changes := p.apply(tc.src, tc.dst)
+		if got != tc.exp || changes[tc.fix] != tc.n {
+			t.Errorf("%s(%q): got %q (%d changes), expected %q (%d changes)", tc.fix, tc.dst, got, changes[tc.fix], tc.exp, tc.n)
+		}
+	}
+	for _, f := range mdFixes {
+		if !tested[f.Name] {
+			t.Errorf("%s: not tested", f.Name)
+		}
+	}
+}
+
+func TestNewPipeline(t *testing.T) {
+	p, err := newPipeline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range p {
+		if !f.Default {
+			t.Errorf("%s: not a default fix", f.Name)
+		}
+	}
+	if p, err = newPipeline("emphasis", " adjacent-links", ""); err != nil || len(p) != 2 || p[0].Name != "emphasis" {
+		t.Errorf("unexpected pipeline: %v %v", p, err)
+	}
+	if _, err := newPipeline("emphasis", "nope"); err == nil {
+		t.Error("expected an error for an unknown fix")
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-tent/tent/core"
+	"github.com/go-tent/tent/destination"
+)
+
+// backupDir contains the previous version of the languages, and the ones
+// being written. It must be on the same filesystem as the output.
+var backupDir = os.Getenv("TENT_BACKUPDIR")
+
+// stagedDir is a temporary directory that replaces dir once it's complete,
+// keeping the previous contents in bak. Both are outside of the content root,
+// in backupDir or, if not set, in a directory next to the root.
+type stagedDir struct {
+	dir, tmp, bak string
+}
+
+// newStagedDir creates the temporary directory for the name in root.
+func newStagedDir(root, name string) (*stagedDir, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	base := backupDir
+	if base == "" {
+		base = root + ".bak"
+	}
+	for _, d := range []string{root, base} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, err
+		}
+	}
+	tmp, err := ioutil.TempDir(base, "."+name+"-")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(tmp, 0755); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	return &stagedDir{dir: filepath.Join(root, name), tmp: tmp, bak: filepath.Join(base, name)}, nil
+}
+
+// commit moves dir to the backup and the temporary directory to dir,
+// restoring the backup if that fails.
+func (s *stagedDir) commit() error {
+	if err := os.RemoveAll(s.bak); err != nil {
+		return err
+	}
+	if err := os.Rename(s.dir, s.bak); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(s.tmp, s.dir); err != nil {
+		os.Rename(s.bak, s.dir)
+		return err
+	}
+	return nil
+}
+
+// abort removes the temporary directory, leaving dir untouched.
+func (s *stagedDir) abort() {
+	os.RemoveAll(s.tmp)
+}
+
+// writeTree writes the subcategories of cat to the name directory in root,
+// replacing its contents only if all of them are written.
+func writeTree(root, name string, cat *core.Category) error {
+	s, err := newStagedDir(root, name)
+	if err != nil {
+		return err
+	}
+	if err := writeSubs(destination.NewFile(s.tmp), nil, cat); err != nil {
+		s.abort()
+		return err
+	}
+	return s.commit()
+}
+
+func writeSubs(dst destination.Destination, prefix []string, cat *core.Category) error {
+	for i := range cat.Sub {
+		p := append(prefix[:len(prefix):len(prefix)], cat.Sub[i].ID)
+		if err := WriteCat(dst, p, &cat.Sub[i]); err != nil {
+			return err
+		}
+		if err := writeSubs(dst, p, &cat.Sub[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
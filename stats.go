@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-tent/tent/core"
+)
+
+var statsFormat = os.Getenv("STATS_FORMAT")
+
+// Stats writes the content statistics of each language and category, as a
+// table or as json with STATS_FORMAT.
+func Stats() {
+	src, err := getSource()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	root, err := decodeRoot(src)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rows := contentStats(root)
+	switch statsFormat {
+	case "", "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(w, "lang\tcategory\tsegments\twords\tchecks\tforms\tfields\timages\timage bytes\tcoverage\t")
+		for _, r := range rows {
+			coverage := "-"
+			if r.Components != 0 && r.Lang != projectLang {
+				coverage = fmt.Sprintf("%.1f%%", r.Coverage())
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n", r.Lang, r.Category,
+				r.Segments, r.Words, r.Checks, r.Forms, r.Fields, r.Images, r.ImageBytes, coverage)
+		}
+		err = w.Flush()
+	case "json":
+		err = json.NewEncoder(os.Stdout).Encode(rows)
+	default:
+		err = fmt.Errorf("unknown format: %s", statsFormat)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// statsRow contains the counts of a top category, or of the whole language
+// if Category is "total". Components is the number of segments, checklists and
+// forms of the project language in the same category, Translated the ones
+// that exist in the language and are different.
+type statsRow struct {
+	Lang       string `json:"lang"`
+	Category   string `json:"category,omitempty"`
+	Segments   int    `json:"segments"`
+	Words      int    `json:"words"`
+	Checks     int    `json:"checks"`
+	Forms      int    `json:"forms"`
+	Fields     int    `json:"fields"`
+	Images     int    `json:"images"`
+	ImageBytes int    `json:"image_bytes"`
+	Components int    `json:"components"`
+	Translated int    `json:"translated"`
+}
+
+// Coverage returns the percentage of translated components.
+func (r statsRow) Coverage() float64 {
+	if r.Components == 0 {
+		return 0
+	}
+	return float64(r.Translated) * 100 / float64(r.Components)
+}
+
+// contentStats returns the rows for each top category of each language,
+// including the ones only in the project language, followed by the total.
+func contentStats(root *core.Root) []statsRow {
+	var (
+		idx    = indexTree(root)
+		rows   []statsRow
+		source = findCategory(root.Category, []string{projectLang})
+	)
+	for i := range root.Sub {
+		lang := &root.Sub[i]
+		cats := make([]*core.Category, 0, len(lang.Sub))
+		for j := range lang.Sub {
+			cats = append(cats, &lang.Sub[j])
+		}
+		if source != nil {
+			for j := range source.Sub {
+				if findCategory(lang, []string{source.Sub[j].ID}) == nil {
+					cats = append(cats, &core.Category{ID: source.Sub[j].ID})
+				}
+			}
+		}
+		total := statsRow{Lang: lang.ID, Category: "total"}
+		for _, c := range cats {
+			r := statsRow{Lang: lang.ID, Category: c.ID}
+			r.count(c)
+			r.coverage(idx, lang.ID, c.ID)
+			rows = append(rows, r)
+			total.add(r)
+		}
+		rows = append(rows, total)
+	}
+	return rows
+}
+
+// count adds the contents of the category and its subcategories.
+func (r *statsRow) count(cat *core.Category) {
+	r.Words += len(strings.Fields(cat.Meta["title"] + " " + cat.Meta["description"]))
+	for _, cmp := range cat.Components {
+		switch v := cmp.(type) {
+		case *core.Segment:
+			r.Segments++
+			r.Words += len(strings.Fields(v.Meta["title"])) + len(strings.Fields(string(v.Body)))
+		case *core.Checks:
+			r.countChecks(v.List)
+		case *core.Form:
+			r.Forms++
+			for _, s := range v.Screens {
+				for _, i := range s.Items {
+					r.Fields++
+					r.Words += len(strings.Fields(metaString(i.Meta, "label") + " " + metaString(i.Meta, "hint")))
+				}
+			}
+		case *core.Picture:
+			r.Images++
+			r.ImageBytes += len(v.Data)
+		}
+	}
+	for i := range cat.Sub {
+		r.count(&cat.Sub[i])
+	}
+}
+
+func (r *statsRow) countChecks(list []core.Check) {
+	for _, c := range list {
+		if c.Check != "" {
+			r.Checks++
+		}
+		r.Words += len(strings.Fields(c.Check + " " + c.Label))
+		r.countChecks(c.Children)
+	}
+}
+
+// coverage counts the components of the project language in the category,
+// and the ones translated in the language.
+func (r *statsRow) coverage(idx map[string]treeEntry, lang, category string) {
+	prefix := projectLang + "/" + category + "/"
+	for p, e := range idx {
+		if !strings.HasPrefix(p, prefix) || e.Cmp == nil {
+			continue
+		}
+		if _, ok := e.Cmp.(*core.Picture); ok {
+			continue
+		}
+		r.Components++
+		t, ok := idx[lang+"/"+strings.TrimPrefix(p, projectLang+"/")]
+		if !ok {
+			continue
+		}
+		if isTranslated(e, t) {
+			r.Translated++
+		}
+	}
+}
+
+// isTranslated returns true if the text of the component is different from
+// the source, ignoring the index and the other metadata of segments.
+func isTranslated(src, dst treeEntry) bool {
+	if s, ok := src.Cmp.(*core.Segment); ok {
+		d := dst.Cmp.(*core.Segment)
+		return s.Meta["title"] != d.Meta["title"] || !bytes.Equal(s.Body, d.Body)
+	}
+	c, d := compareEntries(src, dst)
+	return c != nil || d != nil
+}
+
+func (r *statsRow) add(o statsRow) {
+	r.Segments += o.Segments
+	r.Words += o.Words
+	r.Checks += o.Checks
+	r.Forms += o.Forms
+	r.Fields += o.Fields
+	r.Images += o.Images
+	r.ImageBytes += o.ImageBytes
+	r.Components += o.Components
+	r.Translated += o.Translated
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/go-tent/tent/core"
-	"github.com/go-tent/tent/destination"
 	"github.com/go-tent/tent/item"
 	"github.com/go-tent/tent/source"
 	"github.com/go-tent/tent/transifex"
@@ -35,39 +35,53 @@ func TransifexDownload() {
 	if err != nil {
 		log.Fatalln(err)
 	}
+	fixes, err := newPipeline(strings.Split(os.Getenv("TX_MD_FIXES"), ",")...)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	txsrc := make(transifexSource)
-	go txsrc.run(src, resources, langs)
+	go txsrc.run(src, resources, langs, fixes)
 	log.Println("Decoding translations...")
 	if err := root.Decode(&txsrc); err != nil {
 		log.Fatalln(err)
 	}
-	for _, cat := range root.Sub {
-		for l := range links {
-			if err := checkLink(&cat, l); err != nil {
-				log.Printf("link: %s %s - %s", cat.ID, l, err)
+	txFixes.print("Markdown fixes")
+	txIssues.print("Translation issues")
+	txLinks.check(root.Category).print("Links")
+	txFailures.print("Failed resources")
+	var failed bool
+	for _, l := range langs {
+		lang := findCategory(root.Category, []string{l})
+		switch {
+		case lang == nil:
+			log.Println(l, "no translations, not written")
+			failed = true
+		case len(txFailures[l]) != 0:
+			log.Println(l, "failed resources, not written")
+			failed = true
+		default:
+			if err := writeTree(outDir, l, lang); err != nil {
+				log.Println(l, err)
+				failed = true
 			}
 		}
 	}
-	dst := destination.NewFile(outDir)
-	prefix := make([]string, 0, 4)
-	for _, cat := range root.Sub {
-		prefix = append(prefix[:1], cat.ID)
-
-		for _, cat := range cat.Sub {
-			prefix = append(prefix[:2], cat.ID)
-			WriteCat(dst, prefix, &cat)
-			for _, cat := range cat.Sub {
-				prefix = append(prefix[:3], cat.ID)
-				WriteCat(dst, prefix, &cat)
-				for _, cat := range cat.Sub {
-					prefix = append(prefix[:4], cat.ID)
-					WriteCat(dst, prefix, &cat)
-				}
-			}
-		}
+	if failed {
+		os.Exit(1)
 	}
 }
 
+var (
+	// txLinks contains the links found in downloaded translations.
+	txLinks = make(langLinks)
+	// txFixes contains the markdown repairs made to downloaded translations.
+	txFixes = make(report)
+	// txFailures contains the resources that could not be downloaded or
+	// decoded, a language with failures is not written. Missing resources
+	// and refused translations are only skipped.
+	txFailures = make(report)
+)
+
 type msg struct {
 	item.Item
 	error
@@ -75,10 +89,13 @@ type msg struct {
 
 type transifexSource chan msg
 
-func (t transifexSource) run(src source.Source, resources map[string]transifex.Resource, langs []string) {
+func (t transifexSource) run(src source.Source, resources map[string]transifex.Resource, langs []string, fixes mdPipeline) {
 	defer close(t)
 	for i, err := src.Next(); i != nil; i, err = src.Next() {
 		if err != nil {
+			for _, l := range langs {
+				txFailures.add(l, i.Name(), "%s", err)
+			}
 			t <- msg{nil, err}
 			continue
 		}
@@ -88,20 +105,45 @@ func (t transifexSource) run(src source.Source, resources map[string]transifex.R
 		}
 		r, ok := resources[name]
 		if !ok {
-			log.Println(name, "not found!")
+			for _, l := range langs {
+				txIssues.add(l, name, "resource not found, skipped")
+			}
 			continue
 		}
 		log.Println(r.Slug)
+		original, err := readItem(i)
+		if err != nil {
+			for _, l := range langs {
+				txFailures.add(l, name, "invalid source: %s", err)
+			}
+			t <- msg{nil, fmt.Errorf("%s %s", name, err)}
+			continue
+		}
 		for _, l := range langs {
 			b, err := dstClient.GetTranslationFile(r.Slug, l)
 			if err != nil {
+				txFailures.add(l, name, "%s", err)
 				t <- msg{nil, fmt.Errorf("%s[%s] %s", name, l, err)}
 				continue
 			}
 			body := string(b)
-			body = strings.ReplaceAll(body, "] (", "](")
+			if path.Ext(name) == ".md" {
+				var changes map[string]int
+				body, changes = fixes.apply(string(original), body)
+				for fix, n := range changes {
+					txFixes.add(l, name, "%s x%d", fix, n)
+				}
+			} else {
+				body = strings.ReplaceAll(body, "] (", "](")
+			}
 			body = linkFinder.ReplaceAllStringFunc(body, replaceLinks)
-			t <- msg{item.Memory{ID: "/" + l + "/" + name, Contents: []byte(body)}, nil}
+			txLinks.add(l, name, body)
+			// refused translations are already in txIssues
+			contents := checkTranslation(name, l, original, []byte(body))
+			if contents == nil {
+				continue
+			}
+			t <- msg{item.Memory{ID: "/" + l + "/" + name, Contents: contents}, nil}
 		}
 	}
 }
@@ -116,3 +158,12 @@ func (t transifexSource) Next() (item.Item, error) {
 		return v.Item, nil
 	}
 }
+
+func readItem(i item.Item) ([]byte, error) {
+	r, err := i.Content()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
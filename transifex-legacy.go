@@ -27,6 +27,8 @@ const minTranslated = 0.5
 
 var difficultyTx map[string]map[string]string
 
+var legacyFixes, _ = newPipeline("image-space", "adjacent-links")
+
 type Error struct {
 	Prefix    []string
 	Component core.Component
@@ -106,8 +108,7 @@ func handleChildren(cat *core.Category, prefix []string) error {
 			handleComponent(prefix, segmentSlug(prefix, v.ID), v, func(cmp core.Component, tx []map[string]string) (core.Component, error) {
 				s := *(cmp.(*core.Segment))
 				body := tx[0]["body"]
-				body = strings.Replace(body, "! [", "![", -1)
-				body = strings.Replace(body, ") [", ")[", -1)
+				body, _ = legacyFixes.apply("", body)
 				s.Body = []byte(body)
 				s.Meta["title"] = tx[0]["title"]
 				return &s, nil
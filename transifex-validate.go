@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-tent/tent/core"
+	"github.com/russross/blackfriday"
+)
+
+var (
+	// txIssues contains the problems found comparing translations with their source.
+	txIssues = make(report)
+	// placeholderFinder matches printf verbs and brace placeholders.
+	placeholderFinder = regexp.MustCompile(`%(\(\w+\))?[-+# 0]*\d*(\.\d+)?[sdvfqx]|\$?\{\{?[\w.]+\}?\}`)
+	// strictChecks drops misaligned checklists instead of just reporting them.
+	strictChecks = os.Getenv("TX_STRICT_CHECKS") != ""
+)
+
+// checkTranslation validates a translated resource against its source and
+// returns the contents to use, or nil if the translation is refused.
+func checkTranslation(name, lang string, src, dst []byte) []byte {
+	switch base := path.Base(name); {
+	case strings.HasPrefix(base, "s_") && path.Ext(base) == ".md":
+		checkSegment(name, lang, src, dst)
+	case strings.HasPrefix(base, "f_") && path.Ext(base) == ".yml":
+		return checkForm(name, lang, src, dst)
+	case strings.HasPrefix(base, "c_") && path.Ext(base) == ".yml":
+		return checkChecks(name, lang, src, dst)
+	}
+	return dst
+}
+
+func checkSegment(name, lang string, src, dst []byte) {
+	s, err := new(core.Segment).Decode(name, bytes.NewReader(src))
+	if err != nil {
+		txIssues.add(lang, name, "invalid source: %s", err)
+		return
+	}
+	d, err := new(core.Segment).Decode(name, bytes.NewReader(dst))
+	if err != nil {
+		txIssues.add(lang, name, "invalid translation: %s", err)
+		return
+	}
+	exp := parseStructure(s.(*core.Segment).Body)
+	got := parseStructure(d.(*core.Segment).Body)
+	for _, p := range exp.compare(got) {
+		txIssues.add(lang, name, "%s", p)
+	}
+}
+
+// checkForm restores the values locked by formLocker and checks the
+// placeholders of the translated ones.
+func checkForm(name, lang string, src, dst []byte) []byte {
+	s, err := new(core.Form).Decode(name, bytes.NewReader(src))
+	if err != nil {
+		txIssues.add(lang, name, "invalid source: %s", err)
+		return dst
+	}
+	d, err := new(core.Form).Decode(name, bytes.NewReader(dst))
+	if err != nil {
+		txIssues.add(lang, name, "invalid translation: %s", err)
+		return dst
+	}
+	exp, got := s.(*core.Form), d.(*core.Form)
+	before, err := got.Encode()
+	if err != nil {
+		txIssues.add(lang, name, "invalid translation: %s", err)
+		return dst
+	}
+	var problems []string
+	restore := func(key string, exp, got interface{}) interface{} {
+		v, p := restoreLocked(key, exp, got)
+		problems = append(problems, p...)
+		return v
+	}
+	got.Index = restore("index", exp.Index, got.Index).(float64)
+	if got.Meta == nil {
+		got.Meta = make(map[string]string, len(exp.Meta))
+	}
+	for k, v := range exp.Meta {
+		var g interface{}
+		if t, ok := got.Meta[k]; ok {
+			g = t
+		}
+		got.Meta[k] = restore(k, v, g).(string)
+	}
+	for k := range got.Meta {
+		if _, ok := exp.Meta[k]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: unexpected", k))
+			delete(got.Meta, k)
+		}
+	}
+	if a, b := len(exp.Screens), len(got.Screens); a != b {
+		problems = append(problems, fmt.Sprintf("screens: expected %d, got %d", a, b))
+		got.Screens = exp.Screens
+	}
+	for i := range got.Screens {
+		es, gs := &exp.Screens[i], &got.Screens[i]
+		key := fmt.Sprintf("screens.%d", i)
+		gs.Meta, _ = restore(key, es.Meta, gs.Meta).(core.Map)
+		if a, b := len(es.Items), len(gs.Items); a != b {
+			problems = append(problems, fmt.Sprintf("%s.items: expected %d, got %d", key, a, b))
+			gs.Items = es.Items
+		}
+		for j := range gs.Items {
+			ei, gi := &es.Items[j], &gs.Items[j]
+			key := fmt.Sprintf("%s.items.%d", key, j)
+			gi.Name = restore(key+".name", ei.Name, gi.Name).(string)
+			gi.Type = restore(key+".type", ei.Type, gi.Type).(string)
+			gi.Required = restore(key+".required", ei.Required, gi.Required).(bool)
+			gi.Meta, _ = restore(key, ei.Meta, gi.Meta).(core.Map)
+		}
+	}
+	for _, p := range problems {
+		txIssues.add(lang, name, "%s", p)
+	}
+	after, err := got.Encode()
+	if err != nil {
+		txIssues.add(lang, name, "cannot restore: %s", err)
+		return dst
+	}
+	if bytes.Equal(before, after) {
+		return dst
+	}
+	return after
+}
+
+// restoreLocked compares a translated value with its source, restoring the
+// locked values and checking placeholders in the translatable ones.
+func restoreLocked(key string, exp, got interface{}) (interface{}, []string) {
+	if got == nil && exp != nil {
+		return exp, []string{key + ": missing"}
+	}
+	var problems []string
+	switch e := exp.(type) {
+	case core.Map:
+		g, ok := got.(core.Map)
+		if !ok {
+			return exp, []string{fmt.Sprintf("%s: expected map, got %T", key, got)}
+		}
+		if g == nil {
+			g = make(core.Map, len(e))
+		}
+		for k, v := range e {
+			var p []string
+			g[k], p = restoreLocked(key+"."+k, v, g[k])
+			problems = append(problems, p...)
+		}
+		for k := range g {
+			if _, ok := e[k]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%s: unexpected", key, k))
+				delete(g, k)
+			}
+		}
+		return g, problems
+	case map[interface{}]interface{}:
+		g, ok := got.(map[interface{}]interface{})
+		if !ok {
+			return exp, []string{fmt.Sprintf("%s: expected map, got %T", key, got)}
+		}
+		if g == nil {
+			g = make(map[interface{}]interface{}, len(e))
+		}
+		for k, v := range e {
+			var p []string
+			g[k], p = restoreLocked(fmt.Sprintf("%s.%v", key, k), v, g[k])
+			problems = append(problems, p...)
+		}
+		for k := range g {
+			if _, ok := e[k]; !ok {
+				problems = append(problems, fmt.Sprintf("%s.%v: unexpected", key, k))
+				delete(g, k)
+			}
+		}
+		return g, problems
+	case []interface{}:
+		g, ok := got.([]interface{})
+		if !ok || len(g) != len(e) {
+			return exp, []string{fmt.Sprintf("%s: expected %d elements, got %v", key, len(e), got)}
+		}
+		for i := range e {
+			var p []string
+			g[i], p = restoreLocked(fmt.Sprintf("%s.%d", key, i), e[i], g[i])
+			problems = append(problems, p...)
+		}
+		return g, problems
+	}
+	if len(formLocker{}.KeyTags(key)) == 0 {
+		es, _ := exp.(string)
+		gs, _ := got.(string)
+		return got, compareTargets(key+" placeholder", placeholderFinder.FindAllString(es, -1), placeholderFinder.FindAllString(gs, -1))
+	}
+	if !reflect.DeepEqual(exp, got) {
+		return exp, []string{fmt.Sprintf("%s: expected %v, got %v", key, exp, got)}
+	}
+	return got, nil
+}
+
+// checkChecks verifies that a translated checklist has the same items as the
+// source, in number, kind and nesting.
+func checkChecks(name, lang string, src, dst []byte) []byte {
+	s, err := new(core.Checks).Decode(name, bytes.NewReader(src))
+	if err != nil {
+		txIssues.add(lang, name, "invalid source: %s", err)
+		return dst
+	}
+	d, err := new(core.Checks).Decode(name, bytes.NewReader(dst))
+	if err != nil {
+		txIssues.add(lang, name, "invalid translation: %s", err)
+		if strictChecks {
+			txIssues.add(lang, name, "refused")
+			return nil
+		}
+		return dst
+	}
+	problems := alignChecks("list", s.(*core.Checks).List, d.(*core.Checks).List)
+	for _, p := range problems {
+		txIssues.add(lang, name, "%s", p)
+	}
+	if len(problems) != 0 && strictChecks {
+		txIssues.add(lang, name, "refused")
+		return nil
+	}
+	return dst
+}
+
+func alignChecks(key string, exp, got []core.Check) (problems []string) {
+	if a, b := len(exp), len(got); a != b {
+		return []string{fmt.Sprintf("%s: expected %d items, got %d", key, a, b)}
+	}
+	for i := range exp {
+		key := fmt.Sprintf("%s.%d", key, i)
+		if a, b := checkKind(exp[i]), checkKind(got[i]); a != b {
+			problems = append(problems, fmt.Sprintf("%s: expected %s, got %s", key, a, b))
+		}
+		problems = append(problems, alignChecks(key+".children", exp[i].Children, got[i].Children)...)
+	}
+	return problems
+}
+
+func checkKind(c core.Check) string {
+	switch {
+	case c.Label != "" && c.Check != "":
+		return "label and check"
+	case c.Label != "":
+		return "label"
+	case c.Check != "":
+		return "check"
+	default:
+		return "empty item"
+	}
+}
+
+// mdStructure is the outline of a markdown document.
+type mdStructure struct {
+	Headings []int
+	Lists    []int
+	Links    []string
+	Images   []string
+}
+
+func parseStructure(body []byte) mdStructure {
+	var s mdStructure
+	md := blackfriday.New(blackfriday.WithExtensions(blackfriday.CommonExtensions))
+	md.Parse(body).Walk(func(n *blackfriday.Node, entering bool) blackfriday.WalkStatus {
+		if !entering {
+			return blackfriday.GoToNext
+		}
+		switch n.Type {
+		case blackfriday.Heading:
+			s.Headings = append(s.Headings, n.Level)
+		case blackfriday.List:
+			var items int
+			for c := n.FirstChild; c != nil; c = c.Next {
+				items++
+			}
+			s.Lists = append(s.Lists, items)
+		case blackfriday.Link:
+			s.Links = append(s.Links, string(n.Destination))
+		case blackfriday.Image:
+			s.Images = append(s.Images, string(n.Destination))
+		}
+		return blackfriday.GoToNext
+	})
+	return s
+}
+
+// compare returns the differences between the expected structure and got.
+func (s mdStructure) compare(got mdStructure) (problems []string) {
+	if a, b := len(s.Headings), len(got.Headings); a != b {
+		problems = append(problems, fmt.Sprintf("headings: expected %d, got %d", a, b))
+	} else {
+		for i := range s.Headings {
+			if a, b := s.Headings[i], got.Headings[i]; a != b {
+				problems = append(problems, fmt.Sprintf("heading %d: expected level %d, got %d", i+1, a, b))
+			}
+		}
+	}
+	if a, b := len(s.Lists), len(got.Lists); a != b {
+		problems = append(problems, fmt.Sprintf("lists: expected %d, got %d", a, b))
+	} else {
+		for i := range s.Lists {
+			if a, b := s.Lists[i], got.Lists[i]; a != b {
+				problems = append(problems, fmt.Sprintf("list %d: expected %d items, got %d", i+1, a, b))
+			}
+		}
+	}
+	problems = append(problems, compareTargets("link", s.Links, got.Links)...)
+	problems = append(problems, compareTargets("image", s.Images, got.Images)...)
+	return problems
+}
+
+// compareTargets returns the missing and unexpected elements of got.
+func compareTargets(kind string, exp, got []string) (problems []string) {
+	var count = make(map[string]int, len(exp))
+	for _, v := range exp {
+		count[v]++
+	}
+	for _, v := range got {
+		count[v]--
+	}
+	for _, v := range exp {
+		if count[v] > 0 {
+			problems = append(problems, fmt.Sprintf("missing %s %q", kind, v))
+			count[v]--
+		}
+	}
+	for _, v := range got {
+		if count[v] < 0 {
+			problems = append(problems, fmt.Sprintf("unexpected %s %q", kind, v))
+			count[v]++
+		}
+	}
+	return problems
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-tent/tent/core"
+)
+
+func TestCheckFormMissingMeta(t *testing.T) {
+	const src = "title: Incident\nscreens:\n- title: Screen\n  items:\n  - name: a\n    type: text_input\n    label: Name\n"
+	const dst = "title: Incidente\nscreens:\n- title: Schermata\n  items:\n  - name: a\n    type: text_input\n"
+	defer delete(txIssues, "it")
+	out := checkForm("forms/f_incident.yml", "it", []byte(src), []byte(dst))
+	if !strings.Contains(string(out), "label: Name") {
+		t.Errorf("label not restored:\n%s", out)
+	}
+	if len(txIssues["it"]["forms/f_incident.yml"]) == 0 {
+		t.Error("missing label not reported")
+	}
+}
+
+func TestCheckFormMeta(t *testing.T) {
+	const src = "title: Incident\nscreens:\n- title: Screen\n  items:\n  - name: a\n    type: text_input\n"
+	const dst = "extra: x\nscreens:\n- title: Schermata\n  items:\n  - name: a\n    type: text_input\n"
+	defer delete(txIssues, "it")
+	out := string(checkForm("forms/f_incident.yml", "it", []byte(src), []byte(dst)))
+	if !strings.Contains(out, "title: Incident") || strings.Contains(out, "extra") {
+		t.Errorf("metadata not restored:\n%s", out)
+	}
+	issues := strings.Join(txIssues["it"]["forms/f_incident.yml"], "\n")
+	for _, exp := range []string{"title: missing", "extra: unexpected"} {
+		if !strings.Contains(issues, exp) {
+			t.Errorf("%q not reported in %q", exp, issues)
+		}
+	}
+}
+
+func TestRestoreLockedNilMap(t *testing.T) {
+	tests := []struct{ exp, got interface{} }{
+		{core.Map{"label": "Name"}, core.Map(nil)},
+		{map[interface{}]interface{}{"label": "Name"}, map[interface{}]interface{}(nil)},
+	}
+	for _, tc := range tests {
+		v, p := restoreLocked("meta", tc.exp, tc.got)
+		if fmt.Sprint(v) != fmt.Sprint(tc.exp) || len(p) != 1 {
+			t.Errorf("%T: got %v %v", tc.got, v, p)
+		}
+	}
+}